@@ -0,0 +1,357 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerFailures    = 5
+	defaultBreakerOpenTimeout = 10 * time.Second
+	// breakerMaxPending is how many keys written while the breaker is open
+	// are remembered, before the whole cache is to be flushed instead.
+	breakerMaxPending = 10000
+)
+
+// BreakerOpts configures a BreakerCache.
+type BreakerOpts struct {
+	// Failures is how many consecutive failures open the breaker.
+	Failures int
+	// OpenTimeout is how long the breaker stays open before a single call is
+	// let through to probe whether the cache is back.
+	OpenTimeout time.Duration
+	// Fallback, usually an InMemoryCache, serves the calls made while the
+	// breaker is open. Without one they fail with ErrCacheUnavailable.
+	Fallback Cache
+	// IsFailure reports whether err means the cache is unavailable. By
+	// default every error is a failure, except the ones of this package
+	// saying the cache works as intended and JSON encoding errors.
+	IsFailure func(err error) bool
+	// Logger receives the failures opening the breaker, and is told when it
+	// closes again.
+	Logger Logger
+}
+
+func (o BreakerOpts) padDefaults() BreakerOpts {
+	if o.Failures == 0 {
+		o.Failures = defaultBreakerFailures
+	}
+
+	if o.OpenTimeout == 0 {
+		o.OpenTimeout = defaultBreakerOpenTimeout
+	}
+
+	if o.IsFailure == nil {
+		o.IsFailure = isBreakerFailure
+	}
+
+	o.Logger = loggerOrNop(o.Logger)
+	return o
+}
+
+func isBreakerFailure(err error) bool {
+	switch err {
+	case nil, ErrCacheMiss, ErrNotStored, ErrNegativeHit, ErrInvalidValue,
+		ErrLockNotObtained, ErrLockNotHeld:
+		return false
+	}
+
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError, *json.InvalidUnmarshalError,
+		*json.UnsupportedTypeError, *json.UnsupportedValueError, *json.MarshalerError:
+		return false
+	}
+
+	return true
+}
+
+// BreakerState is the state of the circuit breaker of a BreakerCache.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every call fast, or sends it to the fallback.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probing call through.
+	BreakerHalfOpen
+)
+
+// BreakerCache wraps a Cache, usually a RedisCache, with a circuit breaker so
+// that callers stop waiting on it once it is down.
+//
+// After enough consecutive failures the breaker opens, and calls fail at once
+// with ErrCacheUnavailable, or are served by the fallback if there is one.
+// Once OpenTimeout has passed a single call probes the cache, closing the
+// breaker if it succeeds.
+//
+// Writes served by the fallback are not copied over once the cache is back.
+// Instead, the keys written or deleted while the breaker was open, whether
+// they reached the fallback or failed, are deleted from the cache before
+// calls go back to it, so that it does not serve values which were meant to
+// be replaced. After a Flush, or past 10000 such keys, the whole cache is
+// flushed instead.
+type BreakerCache struct {
+	cache       Cache
+	fallback    Cache
+	failures    int
+	openTimeout time.Duration
+	isFailure   func(error) bool
+	logger      Logger
+
+	mu          sync.Mutex
+	state       BreakerState
+	consecutive int
+	openedAt    time.Time
+	pending     map[string]struct{} // Keys to delete from the cache once back.
+	pendingAll  bool                // Whether to flush it instead.
+}
+
+// NewBreakerCache returns a BreakerCache guarding c.
+func NewBreakerCache(c Cache, opts BreakerOpts) *BreakerCache {
+	opts = opts.padDefaults()
+	return &BreakerCache{
+		cache:       c,
+		fallback:    opts.Fallback,
+		failures:    opts.Failures,
+		openTimeout: opts.OpenTimeout,
+		isFailure:   opts.IsFailure,
+		logger:      opts.Logger,
+	}
+}
+
+// State returns the current state of the breaker.
+func (c *BreakerCache) State() BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == BreakerOpen && time.Since(c.openedAt) >= c.openTimeout {
+		return BreakerHalfOpen
+	}
+	return c.state
+}
+
+// allow reports whether a call may go through to the cache, and whether it
+// is the probe of a half-open breaker.
+func (c *BreakerCache) allow() (ok, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case BreakerClosed:
+		return true, false
+	case BreakerOpen:
+		if time.Since(c.openedAt) < c.openTimeout {
+			return false, false
+		}
+		c.state = BreakerHalfOpen
+		return true, true
+	}
+
+	// A probe is already in flight.
+	return false, false
+}
+
+// record updates the breaker with the outcome of a call let through.
+func (c *BreakerCache) record(probe bool, err error) {
+	state, changed := c.transition(probe, err)
+	if !changed {
+		return
+	}
+
+	switch state {
+	case BreakerOpen:
+		c.logger.Error("cache: breaker opened", "error", err, "timeout", c.openTimeout)
+	case BreakerClosed:
+		c.logger.Warn("cache: breaker closed")
+	}
+}
+
+// transition applies the outcome of a call to the state of the breaker,
+// returning the state it is in and whether it moved there.
+func (c *BreakerCache) transition(probe bool, err error) (BreakerState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.isFailure(err) {
+		if probe {
+			c.state, c.consecutive = BreakerClosed, 0
+			return c.state, true
+		}
+
+		if c.state == BreakerClosed {
+			c.consecutive = 0
+		}
+		return c.state, false
+	}
+
+	if probe {
+		c.state, c.openedAt = BreakerOpen, time.Now()
+		return c.state, true
+	}
+
+	c.consecutive++
+	if c.state == BreakerClosed && c.consecutive >= c.failures {
+		c.state, c.openedAt = BreakerOpen, time.Now()
+		return c.state, true
+	}
+	return c.state, false
+}
+
+// invalidate remembers to delete key from the cache once it is back.
+func (c *BreakerCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pendingAll {
+		return
+	}
+
+	if len(c.pending) >= breakerMaxPending {
+		c.pending, c.pendingAll = nil, true
+		return
+	}
+
+	if c.pending == nil {
+		c.pending = map[string]struct{}{}
+	}
+	c.pending[key] = struct{}{}
+}
+
+// invalidateAll remembers to flush the cache once it is back.
+func (c *BreakerCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending, c.pendingAll = nil, true
+}
+
+// replay deletes from the cache what was written while the breaker was open.
+// What could not be deleted is kept for the next probe.
+func (c *BreakerCache) replay() error {
+	c.mu.Lock()
+	keys, all := c.pending, c.pendingAll
+	c.pending, c.pendingAll = nil, false
+	c.mu.Unlock()
+
+	var err error
+	if all {
+		err = c.cache.Flush()
+	} else {
+		for key := range keys {
+			if err = c.cache.Delete(key); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		c.logger.Warn("cache: breaker failed to invalidate the keys written while open", "error", err)
+		if all {
+			c.invalidateAll()
+		} else {
+			for key := range keys {
+				c.invalidate(key)
+			}
+		}
+	}
+	return err
+}
+
+// do runs op against the cache if the breaker lets it through, and against
+// the fallback otherwise. The probe of a half-open breaker first replays the
+// invalidations missed while open. If op changes key and is not let through,
+// rejected remembers it.
+func (c *BreakerCache) do(op func(cache Cache) error, rejected func()) error {
+	ok, probe := c.allow()
+	if probe {
+		if err := c.replay(); c.isFailure(err) {
+			c.record(probe, err)
+			ok = false
+		}
+	}
+
+	if !ok {
+		if rejected != nil {
+			rejected()
+		}
+
+		if c.fallback == nil {
+			return ErrCacheUnavailable
+		}
+		return op(c.fallback)
+	}
+
+	err := op(c.cache)
+	c.record(probe, err)
+	return err
+}
+
+// write is do for ops changing key.
+func (c *BreakerCache) write(key string, op func(cache Cache) error) error {
+	return c.do(op, func() {
+		c.invalidate(key)
+	})
+}
+
+func (c *BreakerCache) Get(key string, ptrValue interface{}) error {
+	return c.do(func(cache Cache) error {
+		return cache.Get(key, ptrValue)
+	}, nil)
+}
+
+func (c *BreakerCache) GetMulti(keys ...string) (Getter, error) {
+	var g Getter
+	err := c.do(func(cache Cache) error {
+		var err error
+		g, err = cache.GetMulti(keys...)
+		return err
+	}, nil)
+	return g, err
+}
+
+func (c *BreakerCache) Set(key string, value interface{}, expires time.Duration) error {
+	return c.write(key, func(cache Cache) error {
+		return cache.Set(key, value, expires)
+	})
+}
+
+func (c *BreakerCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	return c.write(key, func(cache Cache) error {
+		return cache.SetFields(key, value, expires)
+	})
+}
+
+func (c *BreakerCache) Add(key string, value interface{}, expires time.Duration) error {
+	return c.write(key, func(cache Cache) error {
+		return cache.Add(key, value, expires)
+	})
+}
+
+func (c *BreakerCache) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.write(key, func(cache Cache) error {
+		return cache.Replace(key, value, expires)
+	})
+}
+
+func (c *BreakerCache) Delete(key string) error {
+	return c.write(key, func(cache Cache) error {
+		return cache.Delete(key)
+	})
+}
+
+func (c *BreakerCache) Flush() error {
+	return c.do(func(cache Cache) error {
+		return cache.Flush()
+	}, c.invalidateAll)
+}
+
+func (c *BreakerCache) Keys() ([]string, error) {
+	var keys []string
+	err := c.do(func(cache Cache) error {
+		var err error
+		keys, err = cache.Keys()
+		return err
+	}, nil)
+	return keys, err
+}
@@ -0,0 +1,196 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("connection refused")
+
+// downCache is an InMemoryCache which fails every call while down.
+type downCache struct {
+	InMemoryCache
+	down  int32
+	calls int32
+}
+
+func (c *downCache) Get(key string, ptrValue interface{}) error {
+	atomic.AddInt32(&c.calls, 1)
+	if atomic.LoadInt32(&c.down) == 1 {
+		return errDown
+	}
+	return c.InMemoryCache.Get(key, ptrValue)
+}
+
+func (c *downCache) Set(key string, value interface{}, expires time.Duration) error {
+	atomic.AddInt32(&c.calls, 1)
+	if atomic.LoadInt32(&c.down) == 1 {
+		return errDown
+	}
+	return c.InMemoryCache.Set(key, value, expires)
+}
+
+var newBreakerCache = func(t *testing.T, defaultExpiration time.Duration) Cache {
+	return NewBreakerCache(newInMemoryCache(t, defaultExpiration), BreakerOpts{})
+}
+
+func TestBreakerCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newBreakerCache)
+}
+
+func TestBreakerCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newBreakerCache)
+}
+
+func TestBreakerCache_Add(t *testing.T) {
+	testAdd(t, newBreakerCache)
+}
+
+func TestBreakerCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newBreakerCache)
+}
+
+func TestBreakerCache_Open(t *testing.T) {
+	backend := &downCache{InMemoryCache: NewInMemoryCache(time.Hour)}
+	logger := &recordingLogger{}
+	c := NewBreakerCache(backend, BreakerOpts{Failures: 3, OpenTimeout: 100 * time.Millisecond, Logger: logger})
+
+	var value string
+	if err := c.Get("missing", &value); err != ErrCacheMiss {
+		t.Fatalf("Expected a miss, got: %v", err)
+	}
+
+	// Misses are not failures, so they do not count towards opening it.
+	atomic.StoreInt32(&backend.down, 1)
+	for i := 0; i < 3; i++ {
+		if err := c.Get("key", &value); err != errDown {
+			t.Errorf("Expected the backend error, got: %v", err)
+		}
+	}
+
+	if state := c.State(); state != BreakerOpen {
+		t.Fatalf("Expected the breaker to be open, got %v", state)
+	}
+
+	// Calls fail fast without reaching the backend.
+	calls := atomic.LoadInt32(&backend.calls)
+	if err := c.Get("key", &value); err != ErrCacheUnavailable {
+		t.Errorf("Expected ErrCacheUnavailable, got: %v", err)
+	}
+
+	if atomic.LoadInt32(&backend.calls) != calls {
+		t.Errorf("Expected no call to reach the backend")
+	}
+
+	// A failing probe opens it again.
+	time.Sleep(150 * time.Millisecond)
+	if err := c.Get("key", &value); err != errDown {
+		t.Errorf("Expected the probe to reach the backend, got: %v", err)
+	}
+
+	if err := c.Get("key", &value); err != ErrCacheUnavailable {
+		t.Errorf("Expected ErrCacheUnavailable, got: %v", err)
+	}
+
+	// A successful one closes it.
+	atomic.StoreInt32(&backend.down, 0)
+	time.Sleep(150 * time.Millisecond)
+	if err := c.Set("key", "foo", time.Hour); err != nil {
+		t.Errorf("Expected the probe to succeed, got: %v", err)
+	}
+
+	if state := c.State(); state != BreakerClosed {
+		t.Errorf("Expected the breaker to be closed, got %v", state)
+	}
+
+	if err := c.Get("key", &value); err != nil || value != "foo" {
+		t.Errorf("Error getting key: %s / %s", err, value)
+	}
+
+	// It was opened twice, by the failures and by the failing probe, then
+	// closed.
+	entries := logger.Entries()
+	if len(entries) != 3 || !strings.HasPrefix(entries[0], "ERROR cache: breaker opened") ||
+		!strings.HasPrefix(entries[1], "ERROR cache: breaker opened") || !strings.HasPrefix(entries[2], "WARN cache: breaker closed") {
+		t.Errorf("Expected the breaker opening and closing to be logged, got %q", entries)
+	}
+}
+
+func TestBreakerCache_Fallback(t *testing.T) {
+	backend := &downCache{InMemoryCache: NewInMemoryCache(time.Hour)}
+	c := NewBreakerCache(backend, BreakerOpts{
+		Failures: 1,
+		Fallback: NewInMemoryCache(time.Hour),
+	})
+
+	atomic.StoreInt32(&backend.down, 1)
+	c.Set("key", "foo", time.Hour)
+
+	if err := c.Set("key", "bar", time.Hour); err != nil {
+		t.Errorf("Expected the fallback to serve the write, got: %v", err)
+	}
+
+	var value string
+	if err := c.Get("key", &value); err != nil || value != "bar" {
+		t.Errorf("Error getting key from the fallback: %s / %s", err, value)
+	}
+}
+
+func TestBreakerCache_ReplaysInvalidations(t *testing.T) {
+	backend := &downCache{InMemoryCache: NewInMemoryCache(time.Hour)}
+	c := NewBreakerCache(backend, BreakerOpts{
+		Failures:    1,
+		OpenTimeout: 100 * time.Millisecond,
+		Fallback:    NewInMemoryCache(time.Hour),
+	})
+
+	for _, key := range []string{"set", "deleted", "kept"} {
+		backend.InMemoryCache.Set(key, "old", time.Hour)
+	}
+
+	atomic.StoreInt32(&backend.down, 1)
+	c.Get("kept", new(string))
+	if state := c.State(); state != BreakerOpen {
+		t.Fatalf("Expected the breaker to be open, got %v", state)
+	}
+
+	c.Set("set", "new", time.Hour)
+	c.Delete("deleted")
+
+	// The probe deletes what was written meanwhile before reading.
+	atomic.StoreInt32(&backend.down, 0)
+	time.Sleep(150 * time.Millisecond)
+	var value string
+	if err := c.Get("set", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the value replaced while open to be dropped, got: %v / %s", err, value)
+	}
+
+	if err := c.Get("deleted", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the value deleted while open to be dropped, got: %v / %s", err, value)
+	}
+
+	if err := c.Get("kept", &value); err != nil || value != "old" {
+		t.Errorf("Expected untouched values to be kept: %v / %s", err, value)
+	}
+}
+
+func TestBreakerCache_ReplaysFlush(t *testing.T) {
+	backend := &downCache{InMemoryCache: NewInMemoryCache(time.Hour)}
+	c := NewBreakerCache(backend, BreakerOpts{Failures: 1, OpenTimeout: 100 * time.Millisecond})
+	backend.InMemoryCache.Set("a", "old", time.Hour)
+
+	atomic.StoreInt32(&backend.down, 1)
+	c.Get("a", new(string))
+	if err := c.Flush(); err != ErrCacheUnavailable {
+		t.Errorf("Expected ErrCacheUnavailable without a fallback, got: %v", err)
+	}
+
+	atomic.StoreInt32(&backend.down, 0)
+	time.Sleep(150 * time.Millisecond)
+	if err := c.Get("a", new(string)); err != ErrCacheMiss {
+		t.Errorf("Expected the flush to be replayed, got: %v", err)
+	}
+}
@@ -48,9 +48,12 @@ type Getter interface {
 //
 // It is assumed that callers will infrequently check returned errors, since any
 // request should be fulfillable without finding anything in the cache.  As a
-// result, all errors other than ErrCacheMiss and ErrNotStored will be logged to
-// revel.ERROR, so that the developer does not need to check the return value to
-// discover things like deserialization or connection errors.
+// result, the errors a cache recovers from itself, such as lost connections,
+// failed replica reads or records dropped from a damaged file, are logged to
+// the Logger in its options, and wrapping a cache in a LoggingCache logs all
+// errors it returns other than ErrCacheMiss and ErrNotStored, so that the
+// developer does not need to check the return value to discover things like
+// deserialization or connection errors.
 type Cache interface {
 	// The Cache implements a Getter.
 	Getter
@@ -64,6 +67,11 @@ type Cache interface {
 	Set(key string, value interface{}, expires time.Duration) error
 
 	// SetFields will atomically set a field of a Hash.
+	//
+	// Returns:
+	//   - nil if the fields were set
+	//   - ErrNotStored if key is missing, or known to be missing
+	//   - an implementation specific error otherwise
 	SetFields(key string, value map[string]interface{}, expires time.Duration) error
 
 	// Get the content associated multiple keys at once.  On success, the caller
@@ -106,3 +114,33 @@ type Cache interface {
 	// Get all currently set keys. This can be super slow so use with care.
 	Keys() ([]string, error)
 }
+
+const defaultNegativeExpiration = time.Minute
+
+// NegativeCacher is implemented by caches that can remember keys known to be
+// missing from the source of truth, so that looking them up again does not
+// reach it.
+type NegativeCacher interface {
+	// SetNegative stores a tombstone for key. Getting it, directly or through
+	// GetMulti, returns ErrNegativeHit until it expires or is overwritten.
+	// Passing DefaultExpiryTime uses the cache's negative expiration, which is
+	// normally much shorter than the one of values.
+	//
+	// Returns:
+	//   - nil on success
+	//   - an implementation specific error otherwise
+	SetNegative(key string, expires time.Duration) error
+}
+
+// Toucher is implemented by caches that can extend the life of a key without
+// writing its value again.
+type Toucher interface {
+	// Touch makes key expire after expires from now, leaving its value as it
+	// is.
+	//
+	// Returns:
+	//   - nil on success
+	//   - ErrNotStored if the key is missing or negative
+	//   - an implementation specific error otherwise
+	Touch(key string, expires time.Duration) error
+}
@@ -6,6 +6,8 @@ package cache
 
 import (
 	"encoding/json"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -16,6 +18,93 @@ type cacheFactory func(*testing.T, time.Duration) Cache
 
 const testExpiryTime = time.Duration(1) * time.Millisecond
 
+// fakeClock only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// wait lets d pass for cache, advancing the fake clocks of the backends it is
+// made of, and sleeping unless they all have one.
+func wait(cache interface{}, d time.Duration) {
+	if !advance(cache, d) {
+		time.Sleep(d)
+	}
+}
+
+// advance advances the fake clocks of the backends cache is made of, and of
+// the wrappers around them, and reports whether they all have one.
+func advance(cache interface{}, d time.Duration) bool {
+	switch c := cache.(type) {
+	case InMemoryCache:
+		return advanceClock(c.clock, d)
+	case *DiskCache:
+		return advanceClock(c.clock, d)
+	case *FileCache:
+		return advanceClock(c.clock, d)
+	case *SQLCache:
+		return advanceClock(c.clock, d)
+	case *TieredCache:
+		return advanceAll(d, c.local, c.remote)
+	case *ShardedCache:
+		var shards []interface{}
+		for _, shard := range c.shards {
+			shards = append(shards, shard)
+		}
+		return advanceAll(d, shards...)
+	case *BreakerCache:
+		if c.fallback == nil {
+			return advance(c.cache, d)
+		}
+		return advanceAll(d, c.cache, c.fallback)
+	case *RevalidatingCache:
+		own := advanceClock(c.opts.Clock, d)
+		return advance(c.cache, d) && own
+	case *EncryptedCache:
+		return advance(c.cache, d)
+	case *LoggingCache:
+		return advance(c.cache, d)
+	case *InstrumentedCache:
+		return advance(c.cache, d)
+	}
+	return false
+}
+
+// advanceClock advances clock if it is a fake one, reporting whether it was.
+func advanceClock(clock Clock, d time.Duration) bool {
+	fake, ok := clock.(*fakeClock)
+	if ok {
+		fake.Advance(d)
+	}
+	return ok
+}
+
+// advanceAll advances every one of caches, reporting whether they all have a
+// fake clock.
+func advanceAll(d time.Duration, caches ...interface{}) bool {
+	all := true
+	for _, cache := range caches {
+		all = advance(cache, d) && all
+	}
+	return all
+}
+
 // Test typical cache interactions
 func typicalGetSet(t *testing.T, newCache cacheFactory) {
 	var err error
@@ -45,7 +134,7 @@ func expiration(t *testing.T, newCache cacheFactory) {
 	if err = cache.Set("int", value, testExpiryTime); err != nil {
 		t.Errorf("Set failed: %s", err)
 	}
-	time.Sleep(2 * time.Second)
+	wait(cache, 2*time.Second)
 	if err = cache.Get("int", &value); err != ErrCacheMiss {
 		t.Log(value)
 		t.Errorf("Expected CacheMiss, but got: %s", err)
@@ -55,7 +144,7 @@ func expiration(t *testing.T, newCache cacheFactory) {
 	if err = cache.Set("int", value, time.Second); err != nil {
 		t.Errorf("Set failed: %s", err)
 	}
-	time.Sleep(2 * time.Second)
+	wait(cache, 2*time.Second)
 	if err = cache.Get("int", &value); err != ErrCacheMiss {
 		t.Errorf("Expected CacheMiss, but got: %s", err)
 	}
@@ -64,7 +153,7 @@ func expiration(t *testing.T, newCache cacheFactory) {
 	if err = cache.Set("int", value, time.Hour); err != nil {
 		t.Errorf("Set failed: %s", err)
 	}
-	time.Sleep(1 * time.Second)
+	wait(cache, 1*time.Second)
 	if err = cache.Get("int", &value); err != nil {
 		t.Errorf("Expected to get the value, but got: %s", err)
 	}
@@ -73,7 +162,7 @@ func expiration(t *testing.T, newCache cacheFactory) {
 	if err = cache.Set("int", value, ForEverNeverExpiry); err != nil {
 		t.Errorf("Set failed: %s", err)
 	}
-	time.Sleep(1 * time.Second)
+	wait(cache, 1*time.Second)
 	if err = cache.Get("int", &value); err != nil {
 		t.Errorf("Expected to get the value, but got: %s", err)
 	}
@@ -123,7 +212,7 @@ func testReplace(t *testing.T, newCache cacheFactory) {
 	}
 
 	// Wait for it to expire and replace with 3 (unsuccessfully).
-	time.Sleep(2 * time.Second)
+	wait(cache, 2*time.Second)
 	if err = cache.Replace("int", 3, time.Second); err != ErrNotStored {
 		t.Errorf("Expected ErrNotStored, got: %s", err)
 	}
@@ -148,7 +237,7 @@ func testAdd(t *testing.T, newCache cacheFactory) {
 	}
 
 	// Wait for it to expire, and add again.
-	time.Sleep(8 * time.Second)
+	wait(cache, 8*time.Second)
 	if err = cache.Add("int", 3, time.Second*5); err != nil {
 		t.Errorf("Unexpected error adding to cache: %s", err)
 	}
@@ -186,6 +275,10 @@ func testSetFields(t *testing.T, newCache cacheFactory) {
 
 		t.Log(i)
 
+		if i["field"] != "foo" {
+			t.Errorf("Must keep the fields not set. Got %v", i["field"])
+		}
+
 		if v, ok := i["field2"]; !ok {
 			t.Error("Must find field2 set in the Hash")
 		} else {
@@ -198,6 +291,13 @@ func testSetFields(t *testing.T, newCache cacheFactory) {
 		}
 	})
 
+	t.Run("HMSet when the key is missing", func(t *testing.T) {
+		cache := newCache(t, time.Hour)
+		if err := cache.SetFields("missing", map[string]interface{}{"field": 1}, time.Hour); err != ErrNotStored {
+			t.Errorf("Expected ErrNotStored for a missing key, got: %v", err)
+		}
+	})
+
 	t.Run("HMSet when value is not a Hash", func(t *testing.T) {
 		var err error
 		cache := newCache(t, time.Hour)
@@ -232,9 +332,14 @@ func testGetMulti(t *testing.T, newCache cacheFactory) {
 		}
 	}
 
-	g, err := cache.GetMulti(keys...)
+	g, err := cache.GetMulti(append(keys, "missing")...)
 	if err != nil {
-		t.Errorf("Error in get-multi: %s", err)
+		t.Fatalf("Error in get-multi: %s", err)
+	}
+
+	var missing string
+	if err = g.Get("missing", &missing); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss for a missing key: %v", err)
 	}
 
 	var str string
@@ -293,3 +398,132 @@ func testKeys(t *testing.T, newCache cacheFactory) {
 		t.Errorf("Mismatching number of keys: %v != %v ", items, expected)
 	}
 }
+
+func testNegative(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+	negative, ok := cache.(NegativeCacher)
+	if !ok {
+		t.Fatalf("%T does not implement NegativeCacher", cache)
+	}
+
+	if err := negative.SetNegative("missing", time.Second); err != nil {
+		t.Errorf("Error setting a tombstone: %s", err)
+	}
+
+	var value string
+	if err := cache.Get("missing", &value); err != ErrNegativeHit {
+		t.Errorf("Expected ErrNegativeHit on GET for a tombstone: %v", err)
+	}
+
+	if err := cache.Set("value", "foo", time.Hour); err != nil {
+		t.Errorf("Error setting a value: %s", err)
+	}
+
+	g, err := cache.GetMulti("missing", "value")
+	if err != nil {
+		t.Fatalf("Error in get-multi: %s", err)
+	}
+
+	if err = g.Get("missing", &value); err != ErrNegativeHit {
+		t.Errorf("Expected ErrNegativeHit from get-multi for a tombstone: %v", err)
+	}
+
+	if err = g.Get("value", &value); err != nil || value != "foo" {
+		t.Errorf("Error getting value: %s / %s", err, value)
+	}
+
+	// Fields cannot be set on tombstones, any more than on missing keys.
+	if err = cache.SetFields("missing", map[string]interface{}{"field": 1}, time.Hour); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored setting fields of a tombstone: %v", err)
+	}
+
+	// Tombstones expire like values.
+	wait(cache, 2*time.Second)
+	if err = cache.Get("missing", &value); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss for an expired tombstone: %v", err)
+	}
+
+	// And are overwritten by values.
+	if err = negative.SetNegative("missing", DefaultExpiryTime); err != nil {
+		t.Errorf("Error setting a tombstone: %s", err)
+	}
+
+	if err = cache.Set("missing", "found", time.Hour); err != nil {
+		t.Errorf("Error setting a value: %s", err)
+	}
+
+	if err = cache.Get("missing", &value); err != nil || value != "found" {
+		t.Errorf("Expected the value to replace the tombstone: %v / %s", err, value)
+	}
+}
+
+// testConcurrentSetFields checks that concurrent SetFields on one key keep
+// every field.
+func testConcurrentSetFields(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+	if err := cache.Set("hash", map[string]interface{}{}, time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	const writers, fields = 20, 25
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			for j := 0; j < fields; j++ {
+				field := fmt.Sprintf("%d-%d", i, j)
+				if err := cache.SetFields("hash", map[string]interface{}{field: j}, time.Hour); err != nil {
+					t.Errorf("Error setting %s: %s", field, err)
+				}
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	var hash map[string]interface{}
+	if err := cache.Get("hash", &hash); err != nil || len(hash) != writers*fields {
+		t.Errorf("Expected every field to be kept: %v / %d of %d fields", err, len(hash), writers*fields)
+	}
+}
+
+func testTouch(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+	toucher, ok := cache.(Toucher)
+	if !ok {
+		t.Fatalf("%T does not implement Toucher", cache)
+	}
+
+	if err := cache.Set("value", "foo", time.Second); err != nil {
+		t.Errorf("Error setting a value: %s", err)
+	}
+
+	if err := toucher.Touch("value", 3*time.Second); err != nil {
+		t.Errorf("Error touching a value: %s", err)
+	}
+
+	var value string
+	wait(cache, 2*time.Second)
+	if err := cache.Get("value", &value); err != nil || value != "foo" {
+		t.Errorf("Expected a touched value to live on: %v / %s", err, value)
+	}
+
+	wait(cache, 2*time.Second)
+	if err := cache.Get("value", &value); err != ErrCacheMiss {
+		t.Errorf("Expected a touched value to expire in the end: %v", err)
+	}
+
+	if err := toucher.Touch("missing", time.Hour); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored touching a missing key: %v", err)
+	}
+
+	if negative, ok := cache.(NegativeCacher); ok {
+		negative.SetNegative("negative", time.Hour)
+		if err := toucher.Touch("negative", time.Hour); err != ErrNotStored {
+			t.Errorf("Expected ErrNotStored touching a tombstone: %v", err)
+		}
+	}
+}
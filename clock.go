@@ -0,0 +1,19 @@
+package cache
+
+import "time"
+
+// Clock tells the time. The backends expiring items themselves rather than
+// leaving it to a server, InMemoryCache, DiskCache, FileCache and SQLCache,
+// take one, so that tests can advance it to expire items instead of sleeping.
+// RevalidatingCache takes one to tell when values go stale.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock tells the time of the system. It is the Clock used unless
+// another is given.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
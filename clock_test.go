@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdvance(t *testing.T) {
+	// Every backend the shared tests run against expires items by a fake
+	// clock, so that they do not sleep, whichever wrappers it is in.
+	for name, newCache := range map[string]cacheFactory{
+		"memory":       newInMemoryCache,
+		"disk":         newDiskCache,
+		"file":         newFileCache,
+		"tiered":       newTieredCache,
+		"sharded":      newShardedCache,
+		"breaker":      newBreakerCache,
+		"revalidating": newRevalidatingCache,
+		"encrypted":    newEncryptedCache,
+		"logging":      newLoggingCache,
+		"instrumented": newInstrumentedCache,
+	} {
+		cache := newCache(t, time.Hour)
+		if err := cache.Set("value", "foo", time.Second); err != nil {
+			t.Fatalf("%s: Error setting a value: %s", name, err)
+		}
+
+		if !advance(cache, 2*time.Second) {
+			t.Errorf("%s: Expected every clock to be fake", name)
+		}
+
+		var value string
+		if err := cache.Get("value", &value); err != ErrCacheMiss {
+			t.Errorf("%s: Expected the value to have expired, got %v", name, err)
+		}
+	}
+
+	if advance(NewRedisCache(RedisOpts{Host: redisTestServer}), time.Second) {
+		t.Errorf("Expected redis not to have a fake clock")
+	}
+}
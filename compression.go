@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+)
+
+// Compression selects how values are compressed before being stored.
+//
+// Compressed values start with a header byte naming their format. No JSON
+// document starts with one of those bytes, so compressed values and values
+// stored uncompressed, including by older versions, can be read side by side
+// whatever the Compression currently in use.
+type Compression byte
+
+const (
+	// NoCompression stores values as they are.
+	NoCompression Compression = iota
+
+	// GzipCompression compresses values with gzip.
+	GzipCompression
+
+	// FlateCompression compresses values with raw DEFLATE at its fastest
+	// level, trading some ratio for speed.
+	FlateCompression
+)
+
+// Values shorter than this many bytes are not worth compressing.
+const defaultCompressionThreshold = 1024
+
+// compress returns b compressed and prefixed with its header byte, or b itself
+// when compression is off or b is shorter than threshold.
+func compress(c Compression, threshold int, b []byte) ([]byte, error) {
+	if c == NoCompression || len(b) < threshold {
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(c))
+
+	var err error
+	switch c {
+	case GzipCompression:
+		w := gzip.NewWriter(&buf)
+		if _, err = w.Write(b); err == nil {
+			err = w.Close()
+		}
+	case FlateCompression:
+		var w *flate.Writer
+		if w, err = flate.NewWriter(&buf, flate.BestSpeed); err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(b); err == nil {
+			err = w.Close()
+		}
+	default:
+		return nil, ErrInvalidValue
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress reverses compress, returning b itself if it was not compressed.
+func decompress(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return b, nil
+	}
+
+	switch Compression(b[0]) {
+	case GzipCompression:
+		r, err := gzip.NewReader(bytes.NewReader(b[1:]))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case FlateCompression:
+		r := flate.NewReader(bytes.NewReader(b[1:]))
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	value, _ := json.Marshal(map[string]string{
+		"body": string(bytes.Repeat([]byte("compress me "), 200)),
+	})
+
+	for _, c := range []Compression{GzipCompression, FlateCompression} {
+		b, err := compress(c, 1024, value)
+		if err != nil {
+			t.Fatalf("Error compressing with %d: %s", c, err)
+		}
+
+		if b[0] != byte(c) {
+			t.Errorf("Expected header byte %d, got %d", c, b[0])
+		}
+
+		if len(b) >= len(value) {
+			t.Errorf("Expected %d to shrink the value, got %d bytes from %d", c, len(b), len(value))
+		}
+
+		out, err := decompress(b)
+		if err != nil {
+			t.Fatalf("Error decompressing with %d: %s", c, err)
+		}
+
+		if !bytes.Equal(out, value) {
+			t.Errorf("Expected the value back from %d", c)
+		}
+	}
+}
+
+func TestCompression_Threshold(t *testing.T) {
+	value := []byte(`"short"`)
+
+	for _, c := range []Compression{NoCompression, GzipCompression, FlateCompression} {
+		b, err := compress(c, 1024, value)
+		if err != nil || !bytes.Equal(b, value) {
+			t.Errorf("Expected %d to leave a short value alone: %v / %q", c, err, b)
+		}
+	}
+
+	// Values stored without compression are read back as they are.
+	out, err := decompress(value)
+	if err != nil || !bytes.Equal(out, value) {
+		t.Errorf("Expected an uncompressed value back: %v / %q", err, out)
+	}
+}
@@ -0,0 +1,475 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiskOpts configures a DiskCache.
+type DiskOpts struct {
+	// Path of the log file, created if missing.
+	Path       string
+	Expiration time.Duration
+	// MaxSize caps the bytes used by live items. Once over it, the oldest
+	// items are evicted. Zero means no limit.
+	MaxSize int64
+	// SyncWrites flushes every write to stable storage before returning.
+	SyncWrites bool
+	// Logger receives the records dropped when the file is opened, cut short
+	// by a crash or corrupt.
+	Logger Logger
+	// Clock decides when items expire. Defaults to the system clock.
+	Clock Clock
+}
+
+func (o DiskOpts) padDefaults() DiskOpts {
+	if o.Clock == nil {
+		o.Clock = SystemClock{}
+	}
+
+	o.Logger = loggerOrNop(o.Logger)
+	return o
+}
+
+// Logs smaller than this are never compacted.
+const diskCompactionMinSize = 1 << 20
+
+// DiskCache is a Cache persisted to a single file on local disk, surviving
+// restarts without the need for a server.
+//
+// Every write is appended to the file as a JSON record, and only an index of
+// where the live records are is kept in memory. The file is replayed when
+// opened, and rewritten with only the live records once most of it is dead.
+type DiskCache struct {
+	mu                sync.Mutex
+	f                 *os.File
+	path              string
+	size              int64 // Size of the file.
+	live              int64 // Bytes of the file used by live records.
+	index             map[string]diskEntry
+	defaultExpiration time.Duration
+	maxSize           int64
+	syncWrites        bool
+	logger            Logger
+	clock             Clock
+	events            eventHandlers
+}
+
+type diskEntry struct {
+	offset    int64
+	size      int64
+	expiresAt int64
+}
+
+func (e diskEntry) expired(now int64) bool {
+	return e.expiresAt != 0 && now > e.expiresAt
+}
+
+type diskRecord struct {
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+	// ExpiresAt is when the item expires, in Unix nanoseconds. Zero means
+	// never.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// NewDiskCache opens the DiskCache stored at opts.Path.
+func NewDiskCache(opts DiskOpts) (*DiskCache, error) {
+	opts = opts.padDefaults()
+	f, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		f:                 f,
+		path:              opts.Path,
+		index:             map[string]diskEntry{},
+		defaultExpiration: opts.Expiration,
+		maxSize:           opts.MaxSize,
+		syncWrites:        opts.SyncWrites,
+		logger:            opts.Logger,
+		clock:             opts.Clock,
+	}
+
+	if err := c.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// replay rebuilds the index from the file. A record cut short by a crash,
+// left unterminated at the end of the log, is truncated away. Complete records
+// which cannot be decoded are skipped, leaving the ones after them alone.
+func (c *DiskCache) replay() error {
+	if _, err := c.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	now := c.clock.Now().UnixNano()
+	r := bufio.NewReader(c.f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) == 0 {
+				return nil
+			}
+
+			c.logger.Warn("cache: truncating a record cut short", "path", c.path, "offset", c.size, "bytes", len(line))
+			return c.f.Truncate(c.size)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var rec diskRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			c.logger.Warn("cache: skipping a corrupt record", "path", c.path, "offset", c.size, "error", err)
+			c.size += int64(len(line))
+			continue
+		}
+
+		c.drop(rec.Key)
+		e := diskEntry{offset: c.size, size: int64(len(line)), expiresAt: rec.ExpiresAt}
+		if !rec.Deleted && !e.expired(now) {
+			c.index[rec.Key] = e
+			c.live += e.size
+		}
+		c.size += int64(len(line))
+	}
+}
+
+// Close closes the file. The cache cannot be used afterwards.
+func (c *DiskCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.f.Close()
+}
+
+// OnEvent implements EventSource. Expired keys are reported once they are
+// looked up or compacted away.
+func (c *DiskCache) OnEvent(fn func(Event)) {
+	c.events.add(fn)
+}
+
+// drop removes key from the index. The caller holds c.mu.
+func (c *DiskCache) drop(key string) {
+	if e, ok := c.index[key]; ok {
+		c.live -= e.size
+		delete(c.index, key)
+	}
+}
+
+// lookup returns the live entry of key. The caller holds c.mu.
+func (c *DiskCache) lookup(key string) (diskEntry, bool) {
+	e, ok := c.index[key]
+	if ok && e.expired(c.clock.Now().UnixNano()) {
+		c.drop(key)
+		c.events.emit(EventExpire, key)
+		return e, false
+	}
+	return e, ok
+}
+
+// read returns the value of key. The caller holds c.mu.
+func (c *DiskCache) read(key string) (json.RawMessage, error) {
+	e, ok := c.lookup(key)
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	line := make([]byte, e.size)
+	if _, err := c.f.ReadAt(line, e.offset); err != nil {
+		return nil, err
+	}
+
+	var rec diskRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return nil, err
+	}
+	return rec.Value, nil
+}
+
+// append writes rec at the end of the file. The caller holds c.mu.
+func (c *DiskCache) append(rec diskRecord) (diskEntry, error) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return diskEntry{}, err
+	}
+	line = append(line, '\n')
+
+	e := diskEntry{offset: c.size, size: int64(len(line)), expiresAt: rec.ExpiresAt}
+	if _, err := c.f.WriteAt(line, e.offset); err != nil {
+		return diskEntry{}, err
+	}
+	c.size += e.size
+
+	if c.syncWrites {
+		if err := c.f.Sync(); err != nil {
+			return diskEntry{}, err
+		}
+	}
+	return e, nil
+}
+
+// write stores value under key, evicting and compacting as needed. The caller
+// holds c.mu.
+func (c *DiskCache) write(key string, value interface{}, expires time.Duration) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if expires == DefaultExpiryTime {
+		expires = c.defaultExpiration
+	}
+
+	rec := diskRecord{Key: key, Value: b}
+	if expires > 0 {
+		rec.ExpiresAt = c.clock.Now().Add(expires).UnixNano()
+	}
+
+	e, err := c.append(rec)
+	if err != nil {
+		return err
+	}
+
+	c.drop(key)
+	c.index[key] = e
+	c.live += e.size
+
+	c.events.emit(EventSet, key)
+	if err := c.evict(key); err != nil {
+		return err
+	}
+	return c.maybeCompact()
+}
+
+// remove deletes key, recording it so that replay does not bring it back.
+// The caller holds c.mu.
+func (c *DiskCache) remove(key string) error {
+	if _, ok := c.index[key]; !ok {
+		return nil
+	}
+
+	c.drop(key)
+	_, err := c.append(diskRecord{Key: key, Deleted: true})
+	return err
+}
+
+// evict removes the oldest items, but not keep, while live items use more
+// than the maximum size. The caller holds c.mu.
+func (c *DiskCache) evict(keep string) error {
+	if c.maxSize == 0 || c.live <= c.maxSize {
+		return nil
+	}
+
+	keys := make([]string, 0, len(c.index))
+	for key := range c.index {
+		if key != keep {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return c.index[keys[i]].offset < c.index[keys[j]].offset
+	})
+
+	for _, key := range keys {
+		if c.live <= c.maxSize {
+			break
+		}
+
+		if err := c.remove(key); err != nil {
+			return err
+		}
+		c.events.emit(EventEvict, key)
+	}
+
+	return nil
+}
+
+// maybeCompact compacts the file once most of it is dead. The caller holds
+// c.mu.
+func (c *DiskCache) maybeCompact() error {
+	if c.size < diskCompactionMinSize || c.size < 2*c.live {
+		return nil
+	}
+	return c.compact()
+}
+
+// Compact rewrites the file with only the live items.
+func (c *DiskCache) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compact()
+}
+
+func (c *DiskCache) compact() error {
+	tmp := c.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]diskEntry, len(c.index))
+	var size int64
+	var expired []string
+	now := c.clock.Now().UnixNano()
+	err = func() error {
+		for key, e := range c.index {
+			if e.expired(now) {
+				expired = append(expired, key)
+				continue
+			}
+
+			line := make([]byte, e.size)
+			if _, err := c.f.ReadAt(line, e.offset); err != nil {
+				return err
+			}
+
+			if _, err := f.WriteAt(line, size); err != nil {
+				return err
+			}
+
+			index[key] = diskEntry{offset: size, size: e.size, expiresAt: e.expiresAt}
+			size += e.size
+		}
+		return f.Sync()
+	}()
+
+	if err == nil {
+		err = os.Rename(tmp, c.path)
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	c.f.Close()
+	c.f, c.index, c.size, c.live = f, index, size, size
+	for _, key := range expired {
+		c.events.emit(EventExpire, key)
+	}
+	return nil
+}
+
+func (c *DiskCache) Get(key string, ptrValue interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.read(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, ptrValue)
+}
+
+func (c *DiskCache) GetMulti(keys ...string) (Getter, error) {
+	return c, nil
+}
+
+func (c *DiskCache) Set(key string, value interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(key, value, expires)
+}
+
+func (c *DiskCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.read(key)
+	if err == ErrCacheMiss {
+		return ErrNotStored
+	}
+
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]interface{}{}
+	if err := json.Unmarshal(b, &existing); err != nil {
+		return err
+	}
+
+	for k, v := range value {
+		existing[k] = v
+	}
+	return c.write(key, existing, expires)
+}
+
+func (c *DiskCache) Add(key string, value interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.lookup(key); ok {
+		return ErrNotStored
+	}
+	return c.write(key, value, expires)
+}
+
+func (c *DiskCache) Replace(key string, value interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.lookup(key); !ok {
+		return ErrNotStored
+	}
+	return c.write(key, value, expires)
+}
+
+func (c *DiskCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.index[key]; !ok {
+		return nil
+	}
+
+	if err := c.remove(key); err != nil {
+		return err
+	}
+
+	c.events.emit(EventDelete, key)
+	return nil
+}
+
+func (c *DiskCache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.f.Truncate(0); err != nil {
+		return err
+	}
+
+	c.index = map[string]diskEntry{}
+	c.size, c.live = 0, 0
+	c.events.emit(EventFlush, "")
+	return nil
+}
+
+func (c *DiskCache) Keys() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now().UnixNano()
+	keys := make([]string, 0, len(c.index))
+	for key, e := range c.index {
+		if !e.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
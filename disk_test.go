@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDiskCache(t *testing.T, opts DiskOpts) *DiskCache {
+	if opts.Path == "" {
+		opts.Path = filepath.Join(t.TempDir(), "disk")
+	}
+
+	c, err := NewDiskCache(opts)
+	if err != nil {
+		t.Fatalf("Error opening a disk cache: %s", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+var newDiskCache = func(t *testing.T, defaultExpiration time.Duration) Cache {
+	return newTestDiskCache(t, DiskOpts{Expiration: defaultExpiration, Clock: newFakeClock()})
+}
+
+func TestDiskCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newDiskCache)
+}
+
+func TestDiskCache_SetFields(t *testing.T) {
+	testSetFields(t, newDiskCache)
+}
+
+func TestDiskCache_Expiration(t *testing.T) {
+	expiration(t, newDiskCache)
+}
+
+func TestDiskCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newDiskCache)
+}
+
+func TestDiskCache_Replace(t *testing.T) {
+	testReplace(t, newDiskCache)
+}
+
+func TestDiskCache_Add(t *testing.T) {
+	testAdd(t, newDiskCache)
+}
+
+func TestDiskCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newDiskCache)
+}
+
+func TestDiskCache_Keys(t *testing.T) {
+	testKeys(t, newDiskCache)
+}
+
+func TestDiskCache_Reopen(t *testing.T) {
+	c := newTestDiskCache(t, DiskOpts{Expiration: time.Hour})
+
+	c.Set("value", "foo", time.Hour)
+	c.Set("deleted", "bar", time.Hour)
+	c.Set("short", 1, 100*time.Millisecond)
+	c.Delete("deleted")
+	c.SetFields("missing", map[string]interface{}{"a": 1}, time.Hour)
+	c.Set("fields", map[string]interface{}{"a": 1}, time.Hour)
+	c.SetFields("fields", map[string]interface{}{"b": 2}, time.Hour)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+
+	// Simulate a crash in the middle of a write.
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Error opening the log: %s", err)
+	}
+	f.WriteString(`{"key":"torn","val`)
+	f.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	logger := &recordingLogger{}
+	reopened, err := NewDiskCache(DiskOpts{Path: c.path, Expiration: time.Hour, Logger: logger})
+	if err != nil {
+		t.Fatalf("Error reopening: %s", err)
+	}
+	defer reopened.Close()
+
+	if entries := logger.Entries(); len(entries) != 1 || !strings.Contains(entries[0], "WARN cache: truncating a record cut short") {
+		t.Errorf("Expected the torn record to be logged, got %q", entries)
+	}
+
+	var value string
+	if err := reopened.Get("value", &value); err != nil || value != "foo" {
+		t.Errorf("Error getting value: %s / %s", err, value)
+	}
+
+	for _, key := range []string{"deleted", "short", "missing", "torn"} {
+		if err := reopened.Get(key, &value); err != ErrCacheMiss {
+			t.Errorf("Expected %s to be missing, got: %v", key, err)
+		}
+	}
+
+	var fields map[string]int
+	if err := reopened.Get("fields", &fields); err != nil || fields["a"] != 1 || fields["b"] != 2 {
+		t.Errorf("Error getting fields: %s / %v", err, fields)
+	}
+
+	// Writes after a torn record are not lost on the next reopen.
+	reopened.Set("after", "baz", time.Hour)
+	logger = &recordingLogger{}
+	again, err := NewDiskCache(DiskOpts{Path: c.path, Logger: logger})
+	if err != nil {
+		t.Fatalf("Error reopening: %s", err)
+	}
+	defer again.Close()
+
+	if entries := logger.Entries(); len(entries) != 0 {
+		t.Errorf("Expected nothing to be logged, got %q", entries)
+	}
+
+	if err := again.Get("after", &value); err != nil || value != "baz" {
+		t.Errorf("Error getting after: %s / %s", err, value)
+	}
+}
+
+func TestDiskCache_CorruptRecord(t *testing.T) {
+	c := newTestDiskCache(t, DiskOpts{Expiration: time.Hour})
+	c.Set("a", 1, time.Hour)
+	c.Set("b", 2, time.Hour)
+	c.Set("c", 3, time.Hour)
+	c.Close()
+
+	// Damage the record of b, leaving its line whole.
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		t.Fatalf("Error reading the log: %s", err)
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	lines[1] = strings.Repeat("x", len(lines[1])-1) + "\n"
+	if err := os.WriteFile(c.path, []byte(strings.Join(lines, "")), 0644); err != nil {
+		t.Fatalf("Error writing the log: %s", err)
+	}
+
+	logger := &recordingLogger{}
+	reopened, err := NewDiskCache(DiskOpts{Path: c.path, Logger: logger})
+	if err != nil {
+		t.Fatalf("Error reopening: %s", err)
+	}
+	defer reopened.Close()
+
+	// The records after it are kept, and so is the file.
+	keys, _ := reopened.Keys()
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"a", "c"}) {
+		t.Errorf("Expected a and c to be kept, got %v", keys)
+	}
+
+	if info, err := os.Stat(c.path); err != nil || info.Size() != int64(len(b)) {
+		t.Errorf("Expected the log to be left whole: %v", err)
+	}
+
+	if entries := logger.Entries(); len(entries) != 1 || !strings.Contains(entries[0], "WARN cache: skipping a corrupt record") {
+		t.Errorf("Expected the corrupt record to be logged, got %q", entries)
+	}
+}
+
+func TestDiskCache_MaxSize(t *testing.T) {
+	c := newTestDiskCache(t, DiskOpts{Expiration: time.Hour, MaxSize: 200})
+
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := c.Set(key, "0123456789", time.Hour); err != nil {
+			t.Fatalf("Error setting %s: %s", key, err)
+		}
+	}
+
+	if c.live > 200 {
+		t.Errorf("Expected at most 200 live bytes, got %d", c.live)
+	}
+
+	var value string
+	if err := c.Get("a", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the oldest item to be evicted, got: %v", err)
+	}
+
+	if err := c.Get("f", &value); err != nil {
+		t.Errorf("Expected the newest item to be kept, got: %v", err)
+	}
+}
+
+func TestDiskCache_Compact(t *testing.T) {
+	c := newTestDiskCache(t, DiskOpts{Expiration: time.Hour})
+
+	for i := 0; i < 100; i++ {
+		c.Set("value", i, time.Hour)
+	}
+	c.Set("deleted", "foo", time.Hour)
+	c.Delete("deleted")
+
+	before := c.size
+	if err := c.Compact(); err != nil {
+		t.Fatalf("Error compacting: %s", err)
+	}
+
+	if c.size >= before || c.size != c.live {
+		t.Errorf("Expected only live items to be left, got %d of %d bytes", c.live, c.size)
+	}
+
+	var value int
+	if err := c.Get("value", &value); err != nil || value != 99 {
+		t.Errorf("Error getting value: %s / %d", err, value)
+	}
+
+	reopened, err := NewDiskCache(DiskOpts{Path: c.path})
+	if err != nil {
+		t.Fatalf("Error reopening: %s", err)
+	}
+	defer reopened.Close()
+
+	if keys, _ := reopened.Keys(); len(keys) != 1 {
+		t.Errorf("Expected one key after compaction, got %v", keys)
+	}
+}
@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"time"
+)
+
+// EncryptionKey is an AES key, 16, 24 or 32 bytes long, named by an ID that is
+// stored along with every value it encrypts.
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// EncryptedCache wraps a Cache so that values are encrypted with AES-GCM
+// before being stored.
+//
+// Values are encrypted with the current key, and decrypted with whichever
+// key encrypted them, so keys can be rotated by making the current key an
+// old one. Values are bound to their cache key: a value whose authentication
+// fails, because it was tampered with, moved to another key or encrypted with
+// an unknown key, is never returned and ErrInvalidValue is returned instead.
+type EncryptedCache struct {
+	cache   Cache
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewEncryptedCache returns an EncryptedCache encrypting with current, and
+// still able to decrypt what was encrypted with any of the old keys.
+func NewEncryptedCache(c Cache, current EncryptionKey, old ...EncryptionKey) (*EncryptedCache, error) {
+	e := &EncryptedCache{
+		cache:   c,
+		current: current.ID,
+		aeads:   map[string]cipher.AEAD{},
+	}
+
+	for _, k := range append([]EncryptionKey{current}, old...) {
+		if len(k.ID) == 0 || len(k.ID) > 255 {
+			return nil, ErrInvalidValue
+		}
+
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		e.aeads[k.ID] = aead
+	}
+
+	return e, nil
+}
+
+// seal encrypts value for key. The result is the length of the key ID, the
+// key ID, the nonce and the sealed JSON encoding of value.
+func (c *EncryptedCache) seal(key string, value interface{}) ([]byte, error) {
+	plain, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	aead := c.aeads[c.current]
+	out := make([]byte, 1+len(c.current)+aead.NonceSize())
+	out[0] = byte(len(c.current))
+	copy(out[1:], c.current)
+
+	nonce := out[1+len(c.current):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, nonce, plain, []byte(key)), nil
+}
+
+// open reverses seal.
+func (c *EncryptedCache) open(key string, sealed []byte, ptrValue interface{}) error {
+	if len(sealed) == 0 || len(sealed) < 1+int(sealed[0]) {
+		return ErrInvalidValue
+	}
+
+	aead, ok := c.aeads[string(sealed[1:1+sealed[0]])]
+	if !ok {
+		return ErrInvalidValue
+	}
+
+	sealed = sealed[1+sealed[0]:]
+	if len(sealed) < aead.NonceSize() {
+		return ErrInvalidValue
+	}
+
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, []byte(key))
+	if err != nil {
+		return ErrInvalidValue
+	}
+	return json.Unmarshal(plain, ptrValue)
+}
+
+func (c *EncryptedCache) Get(key string, ptrValue interface{}) error {
+	var sealed []byte
+	if err := c.cache.Get(key, &sealed); err != nil {
+		return err
+	}
+	return c.open(key, sealed, ptrValue)
+}
+
+func (c *EncryptedCache) GetMulti(keys ...string) (Getter, error) {
+	g, err := c.cache.GetMulti(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return encryptedGetter{c: c, g: g}, nil
+}
+
+func (c *EncryptedCache) Set(key string, value interface{}, expires time.Duration) error {
+	sealed, err := c.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return c.cache.Set(key, sealed, expires)
+}
+
+// SetFields decrypts, merges and encrypts back the value, so unlike Set it
+// is not atomic.
+func (c *EncryptedCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	existing := map[string]interface{}{}
+	if err := c.Get(key, &existing); err != nil {
+		if err == ErrCacheMiss || err == ErrNegativeHit {
+			return ErrNotStored
+		}
+		return err
+	}
+
+	for k, v := range value {
+		existing[k] = v
+	}
+	return c.Set(key, existing, expires)
+}
+
+func (c *EncryptedCache) Add(key string, value interface{}, expires time.Duration) error {
+	sealed, err := c.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return c.cache.Add(key, sealed, expires)
+}
+
+func (c *EncryptedCache) Replace(key string, value interface{}, expires time.Duration) error {
+	sealed, err := c.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return c.cache.Replace(key, sealed, expires)
+}
+
+func (c *EncryptedCache) Delete(key string) error {
+	return c.cache.Delete(key)
+}
+
+func (c *EncryptedCache) Flush() error {
+	return c.cache.Flush()
+}
+
+func (c *EncryptedCache) Keys() ([]string, error) {
+	return c.cache.Keys()
+}
+
+// encryptedGetter implements a Getter decrypting the values of an
+// EncryptedCache.
+type encryptedGetter struct {
+	c *EncryptedCache
+	g Getter
+}
+
+func (g encryptedGetter) Get(key string, ptrValue interface{}) error {
+	var sealed []byte
+	if err := g.g.Get(key, &sealed); err != nil {
+		return err
+	}
+	return g.c.open(key, sealed, ptrValue)
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var (
+	testKey    = EncryptionKey{ID: "2018-01", Key: bytes.Repeat([]byte{1}, 32)}
+	testOldKey = EncryptionKey{ID: "2017-01", Key: bytes.Repeat([]byte{2}, 16)}
+)
+
+var newEncryptedCache = func(t *testing.T, defaultExpiration time.Duration) Cache {
+	c, err := NewEncryptedCache(newInMemoryCache(t, defaultExpiration), testKey)
+	if err != nil {
+		t.Fatalf("Error creating cache: %s", err)
+	}
+	return c
+}
+
+func TestEncryptedCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newEncryptedCache)
+}
+
+func TestEncryptedCache_SetFields(t *testing.T) {
+	testSetFields(t, newEncryptedCache)
+}
+
+func TestEncryptedCache_Expiration(t *testing.T) {
+	expiration(t, newEncryptedCache)
+}
+
+func TestEncryptedCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newEncryptedCache)
+}
+
+func TestEncryptedCache_Replace(t *testing.T) {
+	testReplace(t, newEncryptedCache)
+}
+
+func TestEncryptedCache_Add(t *testing.T) {
+	testAdd(t, newEncryptedCache)
+}
+
+func TestEncryptedCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newEncryptedCache)
+}
+
+func TestEncryptedCache_Keys(t *testing.T) {
+	testKeys(t, newEncryptedCache)
+}
+
+func TestEncryptedCache_Opaque(t *testing.T) {
+	inner := NewInMemoryCache(time.Hour)
+	cache, err := NewEncryptedCache(inner, testKey)
+	if err != nil {
+		t.Fatalf("Error creating cache: %s", err)
+	}
+
+	if err := cache.Set("value", "secret", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	var sealed []byte
+	if err := inner.Get("value", &sealed); err != nil {
+		t.Fatalf("Error getting the stored value: %s", err)
+	}
+
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Errorf("Expected the stored value to be encrypted, got %q", sealed)
+	}
+
+	if !bytes.HasPrefix(sealed, append([]byte{byte(len(testKey.ID))}, testKey.ID...)) {
+		t.Errorf("Expected the stored value to name its key, got %q", sealed)
+	}
+}
+
+func TestEncryptedCache_KeyRotation(t *testing.T) {
+	inner := NewInMemoryCache(time.Hour)
+	old, err := NewEncryptedCache(inner, testOldKey)
+	if err != nil {
+		t.Fatalf("Error creating cache: %s", err)
+	}
+
+	if err := old.Set("value", "foo", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	rotated, err := NewEncryptedCache(inner, testKey, testOldKey)
+	if err != nil {
+		t.Fatalf("Error creating cache: %s", err)
+	}
+
+	var value string
+	if err := rotated.Get("value", &value); err != nil || value != "foo" {
+		t.Errorf("Expected to decrypt with the old key: %v / %s", err, value)
+	}
+
+	// New values are encrypted with the current key only.
+	if err := rotated.Set("value", "bar", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	if err := old.Get("value", &value); err != ErrInvalidValue {
+		t.Errorf("Expected ErrInvalidValue without the current key, got: %v", err)
+	}
+}
+
+func TestEncryptedCache_Tampering(t *testing.T) {
+	inner := NewInMemoryCache(time.Hour)
+	cache, err := NewEncryptedCache(inner, testKey)
+	if err != nil {
+		t.Fatalf("Error creating cache: %s", err)
+	}
+
+	if err := cache.Set("value", "foo", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	var sealed []byte
+	if err := inner.Get("value", &sealed); err != nil {
+		t.Fatalf("Error getting the stored value: %s", err)
+	}
+
+	// A value moved to another key does not authenticate.
+	inner.Set("moved", sealed, time.Hour)
+
+	var value string
+	if err := cache.Get("moved", &value); err != ErrInvalidValue {
+		t.Errorf("Expected ErrInvalidValue for a moved value, got: %v / %s", err, value)
+	}
+
+	// Neither does a modified one.
+	sealed[len(sealed)-1] ^= 1
+	inner.Set("value", sealed, time.Hour)
+
+	if err := cache.Get("value", &value); err != ErrInvalidValue {
+		t.Errorf("Expected ErrInvalidValue for a modified value, got: %v / %s", err, value)
+	}
+
+	g, err := cache.GetMulti("value")
+	if err != nil {
+		t.Fatalf("Error in get-multi: %s", err)
+	}
+
+	if err := g.Get("value", &value); err != ErrInvalidValue {
+		t.Errorf("Expected ErrInvalidValue from get-multi for a modified value, got: %v", err)
+	}
+}
+
+func TestEncryptedCache_SetFieldsNegative(t *testing.T) {
+	inner := NewInMemoryCache(time.Hour)
+	cache, err := NewEncryptedCache(inner, testKey)
+	if err != nil {
+		t.Fatalf("Error creating cache: %s", err)
+	}
+
+	inner.SetNegative("missing", time.Hour)
+	if err := cache.SetFields("missing", map[string]interface{}{"field": 1}, time.Hour); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored setting fields of a tombstone: %v", err)
+	}
+}
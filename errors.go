@@ -9,4 +9,14 @@ var (
 	ErrNotStored    = errors.New("cache: item not stored")
 	ErrServerError  = errors.New("cache: server error")
 	ErrInvalidValue = errors.New("cache: invalid value")
+	ErrNegativeHit  = errors.New("cache: key known to be missing")
+
+	ErrLockNotObtained = errors.New("cache: lock not obtained")
+	ErrLockNotHeld     = errors.New("cache: lock not held")
+
+	ErrNoShards         = errors.New("cache: no shards")
+	ErrCacheUnavailable = errors.New("cache: unavailable")
+	ErrLoadPanicked     = errors.New("cache: load panicked")
+	ErrInvalidTable     = errors.New("cache: invalid table name")
+	ErrNoGeneration     = errors.New("cache: no generation")
 )
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+// EventType tells what happened to a key.
+type EventType int
+
+const (
+	// EventSet is emitted when a value is stored, by Set, SetFields, Add or
+	// Replace, or when SetNegative stores that a key is missing.
+	EventSet EventType = iota
+	// EventDelete is emitted when a key is deleted.
+	EventDelete
+	// EventExpire is emitted when an expired key is removed.
+	EventExpire
+	// EventEvict is emitted when a live key is removed to make room.
+	EventEvict
+	// EventFlush is emitted when the whole cache is flushed.
+	EventFlush
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	case EventFlush:
+		return "flush"
+	}
+	return "unknown"
+}
+
+// Event is a change made to a cache.
+type Event struct {
+	Type EventType
+	// Key is the key changed, empty for EventFlush.
+	Key string
+}
+
+// EventSource is implemented by the backends which emit Events.
+//
+// Events report every change to the keys of the store, whoever made it, and
+// the negative entries of SetNegative like values. The keys holding the locks
+// of Lock are left out.
+//
+// Handlers are called synchronously, possibly while the cache is locked: they
+// must return quickly and must not call the cache they are registered on.
+type EventSource interface {
+	OnEvent(fn func(Event))
+}
+
+type eventHandlers struct {
+	mu  sync.RWMutex
+	fns []func(Event)
+}
+
+func (h *eventHandlers) add(fn func(Event)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fns = append(h.fns, fn)
+}
+
+// active reports whether any handler is registered, for the backends which
+// must do extra work to find out about events.
+func (h *eventHandlers) active() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.fns) > 0
+}
+
+func (h *eventHandlers) emit(typ EventType, key string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.fns {
+		fn(Event{Type: typ, Key: key})
+	}
+}
+
+// redisEvents turns the keyspace notifications of a Redis server into Events.
+type redisEvents struct {
+	handlers eventHandlers
+	mu       sync.Mutex
+	pubsub   *redis.PubSub
+	done     chan struct{}
+}
+
+// redisKeyEvents maps the keyspace notifications to the events they are.
+var redisKeyEvents = map[string]EventType{
+	"set":     EventSet,
+	"del":     EventDelete,
+	"expired": EventExpire,
+	"evicted": EventEvict,
+}
+
+// OnEvent implements EventSource by subscribing to the keyspace notifications
+// of the server on the first call. The server must be configured to send
+// them, with notify-keyspace-events including at least "Eg$xe".
+//
+// Events are delivered asynchronously, for the changes made by every client of
+// the database, since they share its keys. Flush events are only emitted for
+// the calls to Flush made through c.
+func (c *RedisCache) OnEvent(fn func(Event)) {
+	c.events.handlers.add(fn)
+
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+	if c.events.pubsub != nil {
+		return
+	}
+
+	prefix := fmt.Sprintf("__keyevent@%d__:", c.pool.Options().DB)
+	pubsub := c.pool.PSubscribe(prefix + "*")
+
+	// Wait for the subscription to be confirmed, so that nothing changed
+	// after we return is missed.
+	if _, err := pubsub.Receive(); err != nil {
+		c.logger.Error("cache: cannot subscribe to keyspace notifications", "error", err)
+	}
+
+	c.events.pubsub = pubsub
+	c.events.done = make(chan struct{})
+	go c.events.receive(pubsub, prefix, c.events.done)
+}
+
+func (e *redisEvents) receive(pubsub *redis.PubSub, prefix string, done chan struct{}) {
+	defer close(done)
+
+	for msg := range pubsub.Channel() {
+		if isLockKey(msg.Payload) {
+			continue
+		}
+
+		if typ, ok := redisKeyEvents[strings.TrimPrefix(msg.Channel, prefix)]; ok {
+			e.handlers.emit(typ, msg.Payload)
+		}
+	}
+}
+
+// CloseEvents stops the subscription to keyspace notifications started by
+// OnEvent.
+func (c *RedisCache) CloseEvents() error {
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+	if c.events.pubsub == nil {
+		return nil
+	}
+
+	err := c.events.pubsub.Close()
+	<-c.events.done
+	c.events.pubsub = nil
+	return err
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) record(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// expectEvents waits for asynchronous backends to deliver the expected
+// events.
+func expectEvents(t *testing.T, r *eventRecorder, expected ...Event) {
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(r.Events(), expected) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if events := r.Events(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
+
+// testEvents checks the events of the operations every backend reports.
+func testEvents(t *testing.T, c interface {
+	Cache
+	EventSource
+}) {
+	r := &eventRecorder{}
+	c.OnEvent(r.record)
+
+	c.Set("a", 1, time.Hour)
+	c.Add("a", 2, time.Hour)
+	c.Replace("a", 3, time.Hour)
+	c.Delete("a")
+	c.Delete("missing")
+	c.Flush()
+
+	expectEvents(t, r,
+		Event{Type: EventSet, Key: "a"},
+		Event{Type: EventSet, Key: "a"},
+		Event{Type: EventDelete, Key: "a"},
+		Event{Type: EventFlush},
+	)
+}
+
+func TestInMemoryCache_Events(t *testing.T) {
+	testEvents(t, NewInMemoryCache(time.Hour))
+}
+
+func TestInMemoryCache_ExpireEvents(t *testing.T) {
+	c := NewInMemoryCache(time.Hour)
+	r := &eventRecorder{}
+	c.OnEvent(r.record)
+
+	// What go-cache calls when its janitor removes expired items.
+	c.evicted("a", inMemoryItem{value: 1})
+	c.evicted("negative", inMemoryItem{value: tombstone{}})
+	c.evicted(lockKey("a"), inMemoryItem{value: "token"})
+
+	expectEvents(t, r,
+		Event{Type: EventExpire, Key: "a"},
+		Event{Type: EventExpire, Key: "negative"},
+	)
+}
+
+func TestInMemoryCache_NegativeAndLockEvents(t *testing.T) {
+	c := NewInMemoryCache(time.Hour)
+	r := &eventRecorder{}
+	c.OnEvent(r.record)
+
+	// Negative entries are reported like values, as Redis does, and the keys
+	// holding locks not at all.
+	c.SetNegative("a", time.Hour)
+	lock, err := c.Lock(context.Background(), "a", time.Minute)
+	if err != nil {
+		t.Fatalf("Error locking: %s", err)
+	}
+	lock.Unlock(context.Background())
+	c.Delete("a")
+
+	// Keys of values which merely look like the ones of locks are reported.
+	c.Set("user:1:last-op", 1, time.Hour)
+
+	expectEvents(t, r,
+		Event{Type: EventSet, Key: "a"},
+		Event{Type: EventDelete, Key: "a"},
+		Event{Type: EventSet, Key: "user:1:last-op"},
+	)
+}
+
+func TestDiskCache_Events(t *testing.T) {
+	c := newTestDiskCache(t, DiskOpts{})
+
+	testEvents(t, c)
+}
+
+func TestDiskCache_ExpireAndEvictEvents(t *testing.T) {
+	c := newTestDiskCache(t, DiskOpts{MaxSize: 100})
+
+	r := &eventRecorder{}
+	c.OnEvent(r.record)
+
+	c.Set("short", "0123456789", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.Get("short", new(string))
+
+	c.Set("a", "0123456789", time.Hour)
+	c.Set("b", "0123456789", time.Hour)
+
+	expectEvents(t, r,
+		Event{Type: EventSet, Key: "short"},
+		Event{Type: EventExpire, Key: "short"},
+		Event{Type: EventSet, Key: "a"},
+		Event{Type: EventSet, Key: "b"},
+		Event{Type: EventEvict, Key: "a"},
+	)
+}
+
+func TestFileCache_Events(t *testing.T) {
+	c := newTestFileCache(t, FileOpts{JanitorInterval: -1})
+
+	testEvents(t, c)
+}
+
+func TestFileCache_ExpireAndEvictEvents(t *testing.T) {
+	c := newTestFileCache(t, FileOpts{MaxSize: 1, JanitorInterval: -1})
+
+	r := &eventRecorder{}
+	c.Set("short", 1, time.Millisecond)
+	c.Set("long", 2, time.Hour)
+	time.Sleep(5 * time.Millisecond)
+
+	c.OnEvent(r.record)
+	if err := c.Clean(); err != nil {
+		t.Fatalf("Error cleaning: %s", err)
+	}
+
+	expectEvents(t, r,
+		Event{Type: EventExpire, Key: "short"},
+		Event{Type: EventEvict, Key: "long"},
+	)
+}
+
+func TestRedisCache_Events(t *testing.T) {
+	c := newRedisCache(t, time.Hour).(*RedisCache)
+	defer c.CloseEvents()
+
+	r := &eventRecorder{}
+	c.OnEvent(r.record)
+
+	// Servers send these once notify-keyspace-events is set; publish them
+	// ourselves so as not to depend on the configuration.
+	for _, msg := range [][2]string{
+		{"__keyevent@0__:expired", "a"},
+		{"__keyevent@0__:expire", "ignored"},
+		{"__keyevent@1__:del", "other database"},
+		{"__keyevent@0__:set", lockKey("a")},
+		{"__keyevent@0__:set", "user:1:last-op"},
+	} {
+		if err := c.pool.Publish(msg[0], msg[1]).Err(); err != nil {
+			t.Fatalf("Error publishing: %s", err)
+		}
+	}
+
+	expectEvents(t, r,
+		Event{Type: EventExpire, Key: "a"},
+		Event{Type: EventSet, Key: "user:1:last-op"},
+	)
+
+	c.Flush()
+	expectEvents(t, r,
+		Event{Type: EventExpire, Key: "a"},
+		Event{Type: EventSet, Key: "user:1:last-op"},
+		Event{Type: EventFlush},
+	)
+}
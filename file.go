@@ -0,0 +1,513 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOpts configures a FileCache.
+type FileOpts struct {
+	// Dir holding the items, created if missing.
+	Dir        string
+	Expiration time.Duration
+	// MaxSize caps the bytes used by the files of the items. Once over it,
+	// the janitor evicts the least recently used ones. Zero means no limit.
+	MaxSize int64
+	// JanitorInterval is how often expired items are removed and MaxSize is
+	// enforced. A negative interval disables the janitor, leaving it to
+	// calls to Clean.
+	JanitorInterval time.Duration
+	// Logger receives the errors of the janitor.
+	Logger Logger
+	// Clock decides when items expire. Defaults to the system clock.
+	Clock Clock
+}
+
+const defaultJanitorInterval = time.Minute
+
+func (o FileOpts) padDefaults() FileOpts {
+	if o.JanitorInterval == 0 {
+		o.JanitorInterval = defaultJanitorInterval
+	}
+
+	if o.Clock == nil {
+		o.Clock = SystemClock{}
+	}
+
+	o.Logger = loggerOrNop(o.Logger)
+	return o
+}
+
+// FileCache is a Cache keeping each item in its own file under a directory,
+// for values too large to be kept in memory.
+//
+// Files are spread over subdirectories by the hash of their key, and start
+// with a line of metadata followed by the JSON encoded value. They are written
+// next to where they belong and renamed over it, so readers never see partial
+// items. Reading an item bumps the modification time of its file, which is
+// what the janitor evicts by.
+//
+// Add, Replace and SetFields are atomic between users of the same FileCache,
+// but not between processes sharing the directory.
+type FileCache struct {
+	mu                sync.Mutex // For Add / Replace / SetFields prevent writes
+	dir               string
+	defaultExpiration time.Duration
+	maxSize           int64
+	logger            Logger
+	clock             Clock
+	events            eventHandlers
+	done              chan struct{}
+	wg                sync.WaitGroup
+	closeOnce         sync.Once
+}
+
+type fileHeader struct {
+	Key string `json:"key"`
+	// ExpiresAt is when the item expires, in Unix nanoseconds. Zero means
+	// never.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+func (h fileHeader) expired(now int64) bool {
+	return h.ExpiresAt != 0 && now > h.ExpiresAt
+}
+
+// NewFileCache returns a FileCache storing its items under opts.Dir, and
+// starts its janitor.
+func NewFileCache(opts FileOpts) (*FileCache, error) {
+	opts = opts.padDefaults()
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &FileCache{
+		dir:               opts.Dir,
+		defaultExpiration: opts.Expiration,
+		maxSize:           opts.MaxSize,
+		logger:            opts.Logger,
+		clock:             opts.Clock,
+		done:              make(chan struct{}),
+	}
+
+	if opts.JanitorInterval > 0 {
+		c.wg.Add(1)
+		go c.janitor(opts.JanitorInterval)
+	}
+	return c, nil
+}
+
+func (c *FileCache) janitor(interval time.Duration) {
+	defer c.wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if err := c.Clean(); err != nil {
+				c.logger.Error("cache: janitor failed", "dir", c.dir, "error", err)
+			}
+		}
+	}
+}
+
+// Close stops the janitor. The items are left on disk.
+func (c *FileCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+	})
+	return nil
+}
+
+// OnEvent implements EventSource. Expired and evicted items are reported once
+// cleaned.
+func (c *FileCache) OnEvent(fn func(Event)) {
+	c.events.add(fn)
+}
+
+// path returns where the item of key is stored.
+func (c *FileCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// isHex reports whether name is n lowercase hex digits, as the names of the
+// directories and files made by path are.
+func isHex(name string, n int) bool {
+	if len(name) != n {
+		return false
+	}
+
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// open returns the header of the item stored at path, and a reader positioned
+// at its value. The caller closes the file.
+func (c *FileCache) open(path string) (*os.File, fileHeader, *bufio.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fileHeader{}, nil, err
+	}
+
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		f.Close()
+		return nil, fileHeader{}, nil, err
+	}
+
+	var h fileHeader
+	if err := json.Unmarshal(line, &h); err != nil {
+		f.Close()
+		return nil, fileHeader{}, nil, err
+	}
+	return f, h, r, nil
+}
+
+// read decodes the value of key into ptrValue.
+func (c *FileCache) read(key string, ptrValue interface{}) error {
+	path := c.path(key)
+	f, h, r, err := c.open(path)
+	if os.IsNotExist(err) {
+		return ErrCacheMiss
+	}
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if h.Key != key {
+		return ErrCacheMiss
+	}
+
+	// Expired files are left to Clean: a concurrent Set may have renamed a
+	// new item over this one since it was opened.
+	if h.expired(c.clock.Now().UnixNano()) {
+		return ErrCacheMiss
+	}
+
+	if err := json.NewDecoder(r).Decode(ptrValue); err != nil {
+		return err
+	}
+
+	// Mark the item as recently used for the janitor. Files are written at
+	// the time of the system, so that is the one they are used at too.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return nil
+}
+
+// exists reports whether key has a live item. The caller holds c.mu.
+func (c *FileCache) exists(key string) (bool, error) {
+	err := c.read(key, &json.RawMessage{})
+	if err == ErrCacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// write stores value under key. The caller holds c.mu.
+func (c *FileCache) write(key string, value interface{}, expires time.Duration) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if expires == DefaultExpiryTime {
+		expires = c.defaultExpiration
+	}
+
+	h := fileHeader{Key: key}
+	if expires > 0 {
+		h.ExpiresAt = c.clock.Now().Add(expires).UnixNano()
+	}
+
+	header, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	w.Write(header)
+	w.WriteByte('\n')
+	w.Write(b)
+	err = w.Flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	c.events.emit(EventSet, key)
+	return nil
+}
+
+type fileItem struct {
+	fileHeader
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// items lists the items in the directory, expired or not.
+func (c *FileCache) items() ([]fileItem, error) {
+	var items []fileItem
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		f, h, _, err := c.open(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			// Not one of ours.
+			return nil
+		}
+		f.Close()
+
+		items = append(items, fileItem{
+			fileHeader: h,
+			path:       path,
+			size:       info.Size(),
+			modTime:    info.ModTime(),
+		})
+		return nil
+	})
+
+	return items, err
+}
+
+// evict removes the file of item, unless it was written or read since it was
+// listed, and emits typ for it.
+func (c *FileCache) evict(item fileItem, typ EventType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(item.path)
+	if err == nil && info.ModTime().Equal(item.modTime) && os.Remove(item.path) == nil {
+		c.events.emit(typ, item.Key)
+	}
+}
+
+// Clean removes expired items, and then evicts the least recently used ones
+// while the directory is over its maximum size. It is what the janitor runs.
+func (c *FileCache) Clean() error {
+	items, err := c.items()
+	if err != nil {
+		return err
+	}
+
+	now := c.clock.Now().UnixNano()
+	live := items[:0]
+	var size int64
+	for _, item := range items {
+		if item.expired(now) {
+			c.evict(item, EventExpire)
+			continue
+		}
+
+		live = append(live, item)
+		size += item.size
+	}
+
+	if c.maxSize == 0 || size <= c.maxSize {
+		return nil
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].modTime.Before(live[j].modTime)
+	})
+
+	for _, item := range live {
+		if size <= c.maxSize {
+			break
+		}
+
+		c.evict(item, EventEvict)
+		size -= item.size
+	}
+
+	return nil
+}
+
+func (c *FileCache) Get(key string, ptrValue interface{}) error {
+	return c.read(key, ptrValue)
+}
+
+func (c *FileCache) GetMulti(keys ...string) (Getter, error) {
+	return c, nil
+}
+
+func (c *FileCache) Set(key string, value interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(key, value, expires)
+}
+
+func (c *FileCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	existing := map[string]interface{}{}
+	err := c.read(key, &existing)
+	if err == ErrCacheMiss {
+		return ErrNotStored
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for k, v := range value {
+		existing[k] = v
+	}
+	return c.write(key, existing, expires)
+}
+
+func (c *FileCache) Add(key string, value interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ok, err := c.exists(key)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		return ErrNotStored
+	}
+	return c.write(key, value, expires)
+}
+
+func (c *FileCache) Replace(key string, value interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ok, err := c.exists(key)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrNotStored
+	}
+	return c.write(key, value, expires)
+}
+
+func (c *FileCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := os.Remove(c.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.events.emit(EventDelete, key)
+	return nil
+}
+
+func (c *FileCache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	// Only what path and write create is removed, in case Dir is shared.
+	for _, info := range infos {
+		if !info.IsDir() || !isHex(info.Name(), 2) {
+			continue
+		}
+
+		shard := filepath.Join(c.dir, info.Name())
+		files, err := ioutil.ReadDir(shard)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			name := file.Name()
+			if file.IsDir() || !isHex(name, 2*sha256.Size) && !strings.HasPrefix(name, ".tmp-") {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(shard, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		// Left in place if anything else is in it.
+		os.Remove(shard)
+	}
+
+	c.events.emit(EventFlush, "")
+	return nil
+}
+
+func (c *FileCache) Keys() ([]string, error) {
+	items, err := c.items()
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.clock.Now().UnixNano()
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if !item.expired(now) {
+			keys = append(keys, item.Key)
+		}
+	}
+
+	return keys, nil
+}
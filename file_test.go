@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFileCache(t *testing.T, opts FileOpts) *FileCache {
+	opts.Dir = t.TempDir()
+	c, err := NewFileCache(opts)
+	if err != nil {
+		t.Fatalf("Error opening a file cache: %s", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+var newFileCache = func(t *testing.T, defaultExpiration time.Duration) Cache {
+	return newTestFileCache(t, FileOpts{Expiration: defaultExpiration, Clock: newFakeClock()})
+}
+
+func TestFileCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newFileCache)
+}
+
+func TestFileCache_SetFields(t *testing.T) {
+	testSetFields(t, newFileCache)
+}
+
+func TestFileCache_Expiration(t *testing.T) {
+	expiration(t, newFileCache)
+}
+
+func TestFileCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newFileCache)
+}
+
+func TestFileCache_Replace(t *testing.T) {
+	testReplace(t, newFileCache)
+}
+
+func TestFileCache_Add(t *testing.T) {
+	testAdd(t, newFileCache)
+}
+
+func TestFileCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newFileCache)
+}
+
+func TestFileCache_Keys(t *testing.T) {
+	testKeys(t, newFileCache)
+}
+
+func TestFileCache_Clean(t *testing.T) {
+	c := newTestFileCache(t, FileOpts{
+		Expiration:      time.Hour,
+		MaxSize:         200,
+		JanitorInterval: -1,
+	})
+
+	c.Set("short", "0123456789", 10*time.Millisecond)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		c.Set(key, "0123456789", time.Hour)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Reading an item makes it the most recently used.
+	var value string
+	if err := c.Get("a", &value); err != nil {
+		t.Fatalf("Error getting a: %s", err)
+	}
+
+	if err := c.Clean(); err != nil {
+		t.Fatalf("Error cleaning: %s", err)
+	}
+
+	items, err := c.items()
+	if err != nil {
+		t.Fatalf("Error listing items: %s", err)
+	}
+
+	var size int64
+	for _, item := range items {
+		size += item.size
+	}
+
+	if size > 200 {
+		t.Errorf("Expected at most 200 bytes, got %d", size)
+	}
+
+	if err := c.Get("a", &value); err != nil {
+		t.Errorf("Expected the recently read item to be kept, got: %v", err)
+	}
+
+	for _, key := range []string{"short", "b"} {
+		if err := c.Get(key, &value); err != ErrCacheMiss {
+			t.Errorf("Expected %s to be removed, got: %v", key, err)
+		}
+	}
+}
+
+func TestFileCache_Janitor(t *testing.T) {
+	c := newTestFileCache(t, FileOpts{
+		Expiration:      time.Hour,
+		JanitorInterval: 50 * time.Millisecond,
+	})
+
+	c.Set("short", 1, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+
+	if items, _ := c.items(); len(items) != 0 {
+		t.Errorf("Expected the janitor to remove expired items, got %v", items)
+	}
+}
+
+func TestFileCache_ExpiredGet(t *testing.T) {
+	c := newTestFileCache(t, FileOpts{JanitorInterval: -1})
+
+	c.Set("short", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if err := c.Get("short", new(int)); err != ErrCacheMiss {
+		t.Errorf("Expected an expired item to miss, got: %v", err)
+	}
+
+	// Removing it is left to Clean, which checks the file is the one listed.
+	if items, _ := c.items(); len(items) != 1 {
+		t.Errorf("Expected the expired file to be left for Clean, got %v", items)
+	}
+}
+
+func TestFileCache_FlushKeepsOtherFiles(t *testing.T) {
+	c := newTestFileCache(t, FileOpts{JanitorInterval: -1})
+
+	c.Set("a", 1, time.Hour)
+	shard := filepath.Dir(c.path("a"))
+	others := []string{
+		filepath.Join(c.dir, "notes"),
+		filepath.Join(c.dir, "other", "file"),
+		filepath.Join(shard, "README"),
+	}
+	for _, path := range append(others, filepath.Join(shard, ".tmp-123")) {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", path, err)
+		}
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+
+	if err := c.Get("a", new(int)); err != ErrCacheMiss {
+		t.Errorf("Expected items to be flushed, got: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(shard, ".tmp-123")); !os.IsNotExist(err) {
+		t.Errorf("Expected stray temporary files to be removed, got: %v", err)
+	}
+
+	for _, path := range others {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %s", path, err)
+		}
+	}
+}
+
+func TestFileCache_Reopen(t *testing.T) {
+	c := newTestFileCache(t, FileOpts{Expiration: time.Hour})
+
+	c.Set("value", []byte("blob"), time.Hour)
+	c.Close()
+
+	reopened, err := NewFileCache(FileOpts{Dir: c.dir})
+	if err != nil {
+		t.Fatalf("Error reopening: %s", err)
+	}
+	defer reopened.Close()
+
+	var value []byte
+	if err := reopened.Get("value", &value); err != nil || string(value) != "blob" {
+		t.Errorf("Error getting value: %s / %s", err, value)
+	}
+}
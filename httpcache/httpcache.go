@@ -0,0 +1,256 @@
+// Package httpcache caches HTTP responses in a cache.Cache.
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+// Header telling whether a response was served from the cache.
+const CacheStatusHeader = "X-Cache"
+
+// Values of CacheStatusHeader.
+const (
+	Hit  = "HIT"
+	Miss = "MISS"
+	// Revalidated is a stored response the server confirmed was unchanged.
+	Revalidated = "REVALIDATED"
+)
+
+const keyPrefix = "httpcache:"
+
+// entry is a response, as stored.
+type entry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	// Date is when the response was generated, in Unix nanoseconds.
+	Date int64 `json:"date"`
+	// Expires is when the response stops being fresh, in Unix nanoseconds.
+	Expires int64 `json:"expires"`
+}
+
+func (e *entry) fresh(now time.Time) bool {
+	return now.UnixNano() < e.Expires
+}
+
+// age returns the Age header of the response served at now.
+func (e *entry) age(now time.Time) string {
+	age := now.Sub(time.Unix(0, e.Date)) / time.Second
+	if age < 0 {
+		age = 0
+	}
+	return strconv.FormatInt(int64(age), 10)
+}
+
+// variants is stored under the key of a URL, listing the request headers its
+// responses vary on.
+type variants struct {
+	Vary []string `json:"vary"`
+}
+
+// key returns where the responses to r are stored, given the request headers
+// they vary on.
+func key(r *http.Request, headers []string) string {
+	// Outgoing requests may leave the host to their URL.
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + host + r.URL.RequestURI()))
+	for _, name := range headers {
+		h.Write([]byte("\n" + name + ": " + strings.Join(r.Header[http.CanonicalHeaderKey(name)], ", ")))
+	}
+	return keyPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// vary returns the request headers named by the Vary header, sorted, and
+// whether the response can be stored at all.
+func vary(header http.Header) ([]string, bool) {
+	var names []string
+	for _, v := range header["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names, true
+}
+
+// cacheControl is a parsed Cache-Control header.
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range header["Cache-Control"] {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+
+			name, value := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, value = directive[:i], strings.Trim(directive[i+1:], `"`)
+			}
+			cc[strings.ToLower(name)] = value
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the value of directive as a duration.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// storable reports whether a response with the status and headers, to a
+// request with the headers, may be stored. Private caches, which are not
+// shared between users, store more of them.
+func storable(reqHeader http.Header, status int, header http.Header, private bool) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusNotFound,
+		http.StatusGone, http.StatusPermanentRedirect:
+	default:
+		return false
+	}
+
+	if parseCacheControl(reqHeader).has("no-store") {
+		return false
+	}
+
+	cc := parseCacheControl(header)
+	if cc.has("no-store") {
+		return false
+	}
+
+	if private {
+		return true
+	}
+
+	if cc.has("private") {
+		return false
+	}
+
+	// Responses to authenticated requests are only shared when they say so.
+	if reqHeader.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") {
+		return false
+	}
+
+	// Nor are the cookies of one user handed to another.
+	if header.Get("Set-Cookie") != "" && !cc.has("public") {
+		return false
+	}
+	return true
+}
+
+// lifetime returns how long a response with the headers stays fresh, given
+// when it was received, or ok false if it does not say.
+func lifetime(header http.Header, received time.Time) (d time.Duration, ok bool) {
+	cc := parseCacheControl(header)
+	if cc.has("no-cache") {
+		return 0, true
+	}
+
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d, true
+	}
+
+	if d, ok := cc.seconds("max-age"); ok {
+		return d, true
+	}
+
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// Invalid dates are in the past.
+			return 0, true
+		}
+
+		date := received
+		if t, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = t
+		}
+		return expires.Sub(date), true
+	}
+
+	return 0, false
+}
+
+// matchETag reports whether the If-None-Match header ifNoneMatch matches etag,
+// with the weak comparison conditional GETs use.
+func matchETag(ifNoneMatch, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the stored response to r. The responses are keyed by the
+// request headers named, in addition to the ones they vary on.
+func lookup(c cache.Cache, r *http.Request, headers []string) (*entry, error) {
+	var v variants
+	if err := c.Get(key(r, headers), &v); err != nil {
+		return nil, err
+	}
+
+	var e entry
+	if err := c.Get(key(r, append(headers[:len(headers):len(headers)], v.Vary...)), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// store stores e as the response to r for ttl, unless it varies on every
+// request header.
+func store(c cache.Cache, r *http.Request, headers []string, e *entry, ttl time.Duration) error {
+	names, ok := vary(e.Header)
+	if !ok {
+		return nil
+	}
+
+	if err := c.Set(key(r, headers), variants{Vary: names}, ttl); err != nil {
+		return err
+	}
+	return c.Set(key(r, append(headers[:len(headers):len(headers)], names...)), e, ttl)
+}
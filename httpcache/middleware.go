@@ -0,0 +1,170 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+// Opts configures a Middleware.
+type Opts struct {
+	// Headers are the request headers the responses depend on besides the
+	// ones named by their Vary header, such as "Accept-Language".
+	Headers []string
+	// DefaultTTL is how long the responses which do not say how long they
+	// stay fresh are cached. Zero leaves them uncached.
+	DefaultTTL time.Duration
+	// Logger receives the errors of the cache, which are otherwise treated as
+	// misses.
+	Logger cache.Logger
+}
+
+// Middleware caches the responses of a handler to GET requests.
+//
+// Responses are cached for as long as their Cache-Control max-age, s-maxage or
+// Expires header says, unless they say no-store or private. The ones without
+// an ETag are given one, so that conditional requests are answered with a
+// 304 Not Modified. Responses are buffered before being sent.
+type Middleware struct {
+	cache      cache.Cache
+	headers    []string
+	defaultTTL time.Duration
+	logger     cache.Logger
+}
+
+// NewMiddleware returns a Middleware storing responses in c.
+func NewMiddleware(c cache.Cache, opts Opts) *Middleware {
+	headers := make([]string, len(opts.Headers))
+	for i, name := range opts.Headers {
+		headers[i] = http.CanonicalHeaderKey(name)
+	}
+
+	return &Middleware{
+		cache:      c,
+		headers:    headers,
+		defaultTTL: opts.DefaultTTL,
+		logger:     opts.Logger,
+	}
+}
+
+func (m *Middleware) logError(msg string, r *http.Request, err error) {
+	if m.logger != nil {
+		m.logger.Error(msg, "url", r.URL.String(), "error", err)
+	}
+}
+
+// Handler returns next with its responses cached.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cc := parseCacheControl(r.Header)
+		if !cc.has("no-cache") && !cc.has("no-store") {
+			e, err := lookup(m.cache, r, m.headers)
+			if err == nil && e.fresh(time.Now()) {
+				serve(w, r, e, Hit)
+				return
+			}
+
+			if err != nil && err != cache.ErrCacheMiss {
+				m.logError("httpcache: cannot get response", r, err)
+			}
+		}
+
+		rec := &recorder{header: http.Header{}}
+		next.ServeHTTP(rec, r)
+		e := rec.entry(time.Now())
+
+		if ttl := m.ttl(r, e); ttl > 0 {
+			e.Expires = time.Unix(0, e.Date).Add(ttl).UnixNano()
+			if err := store(m.cache, r, m.headers, e, ttl); err != nil {
+				m.logError("httpcache: cannot store response", r, err)
+			}
+		}
+
+		serve(w, r, e, Miss)
+	})
+}
+
+// ttl returns how long the response e to r is cached, zero for not at all.
+func (m *Middleware) ttl(r *http.Request, e *entry) time.Duration {
+	if !storable(r.Header, e.Status, e.Header, false) {
+		return 0
+	}
+
+	if ttl, ok := lifetime(e.Header, time.Unix(0, e.Date)); ok {
+		return ttl
+	}
+	return m.defaultTTL
+}
+
+// serve writes e as the response to r, or a 304 Not Modified if r already
+// has it.
+func serve(w http.ResponseWriter, r *http.Request, e *entry, status string) {
+	header := w.Header()
+	for name, values := range e.Header {
+		header[name] = values
+	}
+	header.Set(CacheStatusHeader, status)
+	if status == Hit {
+		header.Set("Age", e.age(time.Now()))
+	}
+
+	if e.Status == http.StatusOK && matchETag(r.Header.Get("If-None-Match"), e.Header.Get("ETag")) {
+		header.Del("Content-Length")
+		header.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	w.Write(e.Body)
+}
+
+// recorder buffers the response of a handler.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
+
+// entry returns the response recorded, given an ETag if it has none.
+func (rec *recorder) entry(now time.Time) *entry {
+	rec.WriteHeader(http.StatusOK)
+	e := &entry{
+		Status: rec.status,
+		Header: rec.header,
+		Body:   rec.body.Bytes(),
+		Date:   now.UnixNano(),
+	}
+
+	if e.Status == http.StatusOK && e.Header.Get("ETag") == "" {
+		sum := sha256.Sum256(e.Body)
+		e.Header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	return e
+}
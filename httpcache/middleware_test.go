@@ -0,0 +1,194 @@
+package httpcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+// countingHandler answers with the number of requests it served, with the
+// Cache-Control header given.
+func countingHandler(cacheControl string) (http.Handler, *int32) {
+	var n int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(&n, 1)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintf(w, "%s %d", r.Header.Get("Accept-Language"), count)
+	}), &n
+}
+
+func get(t *testing.T, h http.Handler, header http.Header) *http.Response {
+	r := httptest.NewRequest("GET", "http://example.com/page?q=1", nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func body(t *testing.T, resp *http.Response) string {
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading body: %s", err)
+	}
+	return string(b)
+}
+
+func TestMiddleware_MaxAge(t *testing.T) {
+	next, n := countingHandler("max-age=60")
+	h := NewMiddleware(cache.NewInMemoryCache(time.Hour), Opts{}).Handler(next)
+
+	first := get(t, h, nil)
+	second := get(t, h, nil)
+	if b := body(t, second); b != " 1" || atomic.LoadInt32(n) != 1 {
+		t.Fatalf("Expected the response to be cached, got %q after %d calls", b, *n)
+	}
+
+	if first.Header.Get(CacheStatusHeader) != Miss || second.Header.Get(CacheStatusHeader) != Hit {
+		t.Errorf("Unexpected cache statuses %q, %q", first.Header.Get(CacheStatusHeader), second.Header.Get(CacheStatusHeader))
+	}
+
+	if second.Header.Get("Age") == "" || second.Header.Get("Cache-Control") != "max-age=60" {
+		t.Errorf("Unexpected headers %v", second.Header)
+	}
+}
+
+func TestMiddleware_NotCached(t *testing.T) {
+	for name, test := range map[string]struct {
+		cacheControl string
+		request      http.Header
+		opts         Opts
+	}{
+		"no-store":         {cacheControl: "no-store, max-age=60"},
+		"private":          {cacheControl: "private, max-age=60"},
+		"no lifetime":      {},
+		"request no-store": {cacheControl: "max-age=60", request: http.Header{"Cache-Control": {"no-store"}}},
+		"authorization":    {cacheControl: "max-age=60", request: http.Header{"Authorization": {"secret"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			next, n := countingHandler(test.cacheControl)
+			h := NewMiddleware(cache.NewInMemoryCache(time.Hour), test.opts).Handler(next)
+
+			get(t, h, test.request)
+			get(t, h, test.request)
+			if atomic.LoadInt32(n) != 2 {
+				t.Errorf("Expected the response not to be cached")
+			}
+		})
+	}
+}
+
+func TestMiddleware_DefaultTTL(t *testing.T) {
+	next, n := countingHandler("")
+	h := NewMiddleware(cache.NewInMemoryCache(time.Hour), Opts{DefaultTTL: time.Minute}).Handler(next)
+
+	get(t, h, nil)
+	get(t, h, nil)
+	if atomic.LoadInt32(n) != 1 {
+		t.Errorf("Expected the response to be cached for the default TTL")
+	}
+}
+
+func TestMiddleware_Expires(t *testing.T) {
+	next, n := countingHandler("max-age=1")
+	h := NewMiddleware(cache.NewInMemoryCache(time.Hour), Opts{}).Handler(next)
+
+	get(t, h, nil)
+	time.Sleep(1100 * time.Millisecond)
+	get(t, h, nil)
+	if atomic.LoadInt32(n) != 2 {
+		t.Errorf("Expected the stale response to be replaced")
+	}
+}
+
+func TestMiddleware_RequestNoCache(t *testing.T) {
+	next, n := countingHandler("max-age=60")
+	h := NewMiddleware(cache.NewInMemoryCache(time.Hour), Opts{}).Handler(next)
+
+	get(t, h, nil)
+	get(t, h, http.Header{"Cache-Control": {"no-cache"}})
+	if b := body(t, get(t, h, nil)); b != " 2" || atomic.LoadInt32(n) != 2 {
+		t.Errorf("Expected no-cache to refresh the stored response, got %q", b)
+	}
+}
+
+func TestMiddleware_Vary(t *testing.T) {
+	next, n := countingHandler("max-age=60")
+	h := NewMiddleware(cache.NewInMemoryCache(time.Hour), Opts{}).Handler(next)
+
+	en := http.Header{"Accept-Language": {"en"}}
+	fr := http.Header{"Accept-Language": {"fr"}}
+	get(t, h, en)
+	get(t, h, fr)
+
+	if b := body(t, get(t, h, en)); b != "en 1" {
+		t.Errorf("Expected the English response, got %q", b)
+	}
+
+	if b := body(t, get(t, h, fr)); b != "fr 2" {
+		t.Errorf("Expected the French response, got %q", b)
+	}
+
+	if atomic.LoadInt32(n) != 2 {
+		t.Errorf("Expected one call per language, got %d", *n)
+	}
+}
+
+func TestMiddleware_Headers(t *testing.T) {
+	var n int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, r.Header.Get("X-Tenant"))
+	})
+	h := NewMiddleware(cache.NewInMemoryCache(time.Hour), Opts{Headers: []string{"x-tenant"}}).Handler(next)
+
+	get(t, h, http.Header{"X-Tenant": {"a"}})
+	if b := body(t, get(t, h, http.Header{"X-Tenant": {"b"}})); b != "b" {
+		t.Errorf("Expected the responses to be keyed by tenant, got %q", b)
+	}
+}
+
+func TestMiddleware_ConditionalRequests(t *testing.T) {
+	next, _ := countingHandler("max-age=60")
+	h := NewMiddleware(cache.NewInMemoryCache(time.Hour), Opts{}).Handler(next)
+
+	etag := get(t, h, nil).Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected an ETag")
+	}
+
+	resp := get(t, h, http.Header{"If-None-Match": {etag}})
+	if resp.StatusCode != http.StatusNotModified || body(t, resp) != "" {
+		t.Errorf("Expected a 304, got %d", resp.StatusCode)
+	}
+
+	resp = get(t, h, http.Header{"If-None-Match": {`"other"`}})
+	if resp.StatusCode != http.StatusOK || body(t, resp) != " 1" {
+		t.Errorf("Expected the full response, got %d", resp.StatusCode)
+	}
+}
+
+func TestMiddleware_OtherMethods(t *testing.T) {
+	next, n := countingHandler("max-age=60")
+	h := NewMiddleware(cache.NewInMemoryCache(time.Hour), Opts{}).Handler(next)
+
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "http://example.com/page", nil))
+	}
+
+	if atomic.LoadInt32(n) != 2 {
+		t.Errorf("Expected POST requests not to be cached")
+	}
+}
@@ -0,0 +1,258 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+const defaultKeepStale = 24 * time.Hour
+
+// TransportOpts configures a Transport.
+type TransportOpts struct {
+	// Transport makes the requests which cannot be answered from the cache.
+	// Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// KeepStale is how long responses which can be revalidated are kept
+	// once stale. Defaults to a day.
+	KeepStale time.Duration
+	// Private also stores the responses marked private, and the ones to
+	// requests with an Authorization header, for caches which are not shared
+	// between users.
+	Private bool
+	// Logger receives the errors of the cache, which are otherwise treated as
+	// misses.
+	Logger cache.Logger
+}
+
+func (o TransportOpts) padDefaults() TransportOpts {
+	if o.Transport == nil {
+		o.Transport = http.DefaultTransport
+	}
+
+	if o.KeepStale == 0 {
+		o.KeepStale = defaultKeepStale
+	}
+	return o
+}
+
+// Transport is an http.RoundTripper caching the responses to GET requests,
+// as RFC 9111 describes for a client-side cache.
+//
+// Responses are fresh for as long as their Cache-Control or Expires header
+// says, or a tenth of their age when they only have a Last-Modified header.
+// Stale responses with an ETag or Last-Modified header are revalidated with a
+// conditional request. The CacheStatusHeader of the responses returned tells
+// how they were obtained.
+type Transport struct {
+	cache     cache.Cache
+	transport http.RoundTripper
+	keepStale time.Duration
+	private   bool
+	logger    cache.Logger
+}
+
+// NewTransport returns a Transport storing responses in c.
+func NewTransport(c cache.Cache, opts TransportOpts) *Transport {
+	opts = opts.padDefaults()
+	return &Transport{
+		cache:     c,
+		transport: opts.Transport,
+		keepStale: opts.KeepStale,
+		private:   opts.Private,
+		logger:    opts.Logger,
+	}
+}
+
+func (t *Transport) logError(msg string, req *http.Request, err error) {
+	if t.logger != nil {
+		t.logger.Error(msg, "url", req.URL.String(), "error", err)
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.roundTripUnsafe(req)
+	}
+
+	cc := parseCacheControl(req.Header)
+	conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+	if cc.has("no-store") || conditional || req.Header.Get("Range") != "" {
+		return t.transport.RoundTrip(req)
+	}
+
+	e, err := lookup(t.cache, req, nil)
+	if err != nil && err != cache.ErrCacheMiss {
+		t.logError("httpcache: cannot get response", req, err)
+	}
+
+	if err != nil {
+		return t.fetch(req)
+	}
+
+	now := time.Now()
+	if e.fresh(now) && !cc.has("no-cache") {
+		return e.response(req, Hit, now), nil
+	}
+
+	etag, lastModified := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return t.fetch(req)
+	}
+	return t.revalidate(req, e, etag, lastModified)
+}
+
+// roundTripUnsafe sends a request which may change the resource, and drops
+// the stored responses of its URL if it succeeds.
+func (t *Transport) roundTripUnsafe(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || req.Method == http.MethodHead || req.Method == http.MethodOptions {
+		return resp, err
+	}
+
+	if resp.StatusCode < 400 {
+		get := req.WithContext(req.Context())
+		get.Method = http.MethodGet
+		if err := t.cache.Delete(key(get, nil)); err != nil {
+			t.logError("httpcache: cannot delete response", req, err)
+		}
+	}
+	return resp, nil
+}
+
+// fetch sends req, and stores the response if it may be.
+func (t *Transport) fetch(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.keep(req, resp)
+}
+
+// keep stores resp, the response to req, if it may be.
+func (t *Transport) keep(req *http.Request, resp *http.Response) (*http.Response, error) {
+	resp.Header.Set(CacheStatusHeader, Miss)
+	if !storable(req.Header, resp.StatusCode, resp.Header, t.private) {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	header := cloneHeader(resp.Header)
+	header.Del(CacheStatusHeader)
+	t.store(req, &entry{Status: resp.StatusCode, Header: header, Body: body}, time.Now())
+	return resp, nil
+}
+
+// revalidate asks the server whether e is still the response to req.
+func (t *Transport) revalidate(req *http.Request, e *entry, etag, lastModified string) (*http.Response, error) {
+	conditional := req.WithContext(req.Context())
+	conditional.Header = cloneHeader(req.Header)
+	if etag != "" {
+		conditional.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		conditional.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := t.transport.RoundTrip(conditional)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusNotModified {
+		return t.keep(req, resp)
+	}
+	resp.Body.Close()
+
+	// The 304 carries the headers to update the stored response with.
+	for name, values := range resp.Header {
+		if name != "Content-Length" {
+			e.Header[name] = values
+		}
+	}
+
+	now := time.Now()
+	t.store(req, e, now)
+	return e.response(req, Revalidated, now), nil
+}
+
+// store stores e, received at now, for as long as it is fresh, and then for as
+// long as stale responses are kept if it can be revalidated.
+func (t *Transport) store(req *http.Request, e *entry, now time.Time) {
+	e.Date = now.UnixNano()
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil && date.Before(now) {
+		e.Date = date.UnixNano()
+	}
+
+	fresh, ok := lifetime(e.Header, now)
+	if !ok {
+		fresh = heuristicLifetime(e.Header, time.Unix(0, e.Date))
+	}
+
+	if fresh < 0 {
+		fresh = 0
+	}
+	e.Expires = time.Unix(0, e.Date).Add(fresh).UnixNano()
+
+	ttl := time.Unix(0, e.Expires).Sub(now)
+	if e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != "" {
+		ttl += t.keepStale
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	if err := store(t.cache, req, nil, e, ttl); err != nil {
+		t.logError("httpcache: cannot store response", req, err)
+	}
+}
+
+// heuristicLifetime returns a tenth of the time since the response was last
+// modified, as browsers do.
+func heuristicLifetime(header http.Header, date time.Time) time.Duration {
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil || lastModified.After(date) {
+		return 0
+	}
+	return date.Sub(lastModified) / 10
+}
+
+// response returns e as the response to req, served at now.
+func (e *entry) response(req *http.Request, status string, now time.Time) *http.Response {
+	header := cloneHeader(e.Header)
+	header.Set(CacheStatusHeader, status)
+	header.Set("Age", e.age(now))
+	header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	for name, values := range header {
+		clone[name] = append([]string(nil), values...)
+	}
+	return clone
+}
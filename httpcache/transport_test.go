@@ -0,0 +1,240 @@
+package httpcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+// testServer counts the full responses and the 304s it sends.
+type testServer struct {
+	*httptest.Server
+	full, notModified int32
+}
+
+func newTestServer(handler func(s *testServer, w http.ResponseWriter, r *http.Request)) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(s, w, r)
+	}))
+	return s
+}
+
+// respond answers with a body counting the full responses.
+func (s *testServer) respond(w http.ResponseWriter) {
+	fmt.Fprintf(w, "response %d", atomic.AddInt32(&s.full, 1))
+}
+
+func (s *testServer) notModifiedSince(w http.ResponseWriter) {
+	atomic.AddInt32(&s.notModified, 1)
+	w.WriteHeader(http.StatusNotModified)
+}
+
+func newTestClient() *http.Client {
+	return &http.Client{Transport: NewTransport(cache.NewInMemoryCache(time.Hour), TransportOpts{})}
+}
+
+// fetch gets the page, and returns its body and cache status.
+func fetch(t *testing.T, client *http.Client, method, url string) (string, string) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading body: %s", err)
+	}
+	return string(b), resp.Header.Get(CacheStatusHeader)
+}
+
+func TestTransport_MaxAge(t *testing.T) {
+	s := newTestServer(func(s *testServer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		s.respond(w)
+	})
+	defer s.Close()
+	client := newTestClient()
+
+	if body, status := fetch(t, client, "GET", s.URL); body != "response 1" || status != Miss {
+		t.Errorf("Unexpected first response %q / %s", body, status)
+	}
+
+	if body, status := fetch(t, client, "GET", s.URL); body != "response 1" || status != Hit {
+		t.Errorf("Unexpected second response %q / %s", body, status)
+	}
+
+	if body, _ := fetch(t, client, "GET", s.URL+"?other"); body != "response 2" {
+		t.Errorf("Expected another URL to be fetched, got %q", body)
+	}
+}
+
+func TestTransport_Expires(t *testing.T) {
+	for name, test := range map[string]struct {
+		expires time.Duration
+		full    int32
+	}{
+		"future": {expires: time.Hour, full: 1},
+		"past":   {expires: -time.Hour, full: 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(func(s *testServer, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Expires", time.Now().Add(test.expires).UTC().Format(http.TimeFormat))
+				s.respond(w)
+			})
+			defer s.Close()
+			client := newTestClient()
+
+			fetch(t, client, "GET", s.URL)
+			fetch(t, client, "GET", s.URL)
+			if full := atomic.LoadInt32(&s.full); full != test.full {
+				t.Errorf("Expected %d full responses, got %d", test.full, full)
+			}
+		})
+	}
+}
+
+func TestTransport_NoStore(t *testing.T) {
+	s := newTestServer(func(s *testServer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+		s.respond(w)
+	})
+	defer s.Close()
+	client := newTestClient()
+
+	fetch(t, client, "GET", s.URL)
+	if body, status := fetch(t, client, "GET", s.URL); body != "response 2" || status != Miss {
+		t.Errorf("Expected the response not to be stored, got %q / %s", body, status)
+	}
+}
+
+func TestTransport_RevalidateETag(t *testing.T) {
+	s := newTestServer(func(s *testServer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			s.notModifiedSince(w)
+			return
+		}
+		s.respond(w)
+	})
+	defer s.Close()
+	client := newTestClient()
+
+	fetch(t, client, "GET", s.URL)
+	if body, status := fetch(t, client, "GET", s.URL); body != "response 1" || status != Revalidated {
+		t.Errorf("Unexpected revalidated response %q / %s", body, status)
+	}
+
+	if full, notModified := atomic.LoadInt32(&s.full), atomic.LoadInt32(&s.notModified); full != 1 || notModified != 1 {
+		t.Errorf("Expected one full response and one 304, got %d and %d", full, notModified)
+	}
+}
+
+func TestTransport_RevalidateLastModified(t *testing.T) {
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	s := newTestServer(func(s *testServer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Last-Modified", lastModified)
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			s.notModifiedSince(w)
+			return
+		}
+		s.respond(w)
+	})
+	defer s.Close()
+	client := newTestClient()
+
+	fetch(t, client, "GET", s.URL)
+	if body, status := fetch(t, client, "GET", s.URL); body != "response 1" || status != Revalidated {
+		t.Errorf("Unexpected revalidated response %q / %s", body, status)
+	}
+}
+
+func TestTransport_HeuristicFreshness(t *testing.T) {
+	lastModified := time.Now().Add(-24 * time.Hour).UTC().Format(http.TimeFormat)
+	s := newTestServer(func(s *testServer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", lastModified)
+		s.respond(w)
+	})
+	defer s.Close()
+	client := newTestClient()
+
+	fetch(t, client, "GET", s.URL)
+	if _, status := fetch(t, client, "GET", s.URL); status != Hit {
+		t.Errorf("Expected a response modified a day ago to be fresh, got %s", status)
+	}
+}
+
+func TestTransport_Changed(t *testing.T) {
+	var version int32 = 1
+	s := newTestServer(func(s *testServer, w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, atomic.LoadInt32(&version))
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			s.notModifiedSince(w)
+			return
+		}
+		s.respond(w)
+	})
+	defer s.Close()
+	client := newTestClient()
+
+	fetch(t, client, "GET", s.URL)
+	atomic.StoreInt32(&version, 2)
+	if body, status := fetch(t, client, "GET", s.URL); body != "response 2" || status != Miss {
+		t.Errorf("Expected the changed response, got %q / %s", body, status)
+	}
+
+	if body, status := fetch(t, client, "GET", s.URL); body != "response 2" || status != Revalidated {
+		t.Errorf("Expected the changed response to be stored, got %q / %s", body, status)
+	}
+}
+
+func TestTransport_UnsafeMethods(t *testing.T) {
+	s := newTestServer(func(s *testServer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		s.respond(w)
+	})
+	defer s.Close()
+	client := newTestClient()
+
+	fetch(t, client, "GET", s.URL)
+	fetch(t, client, "POST", s.URL)
+	if body, status := fetch(t, client, "GET", s.URL); body != "response 3" || status != Miss {
+		t.Errorf("Expected the POST to invalidate the stored response, got %q / %s", body, status)
+	}
+}
+
+func TestTransport_Private(t *testing.T) {
+	s := newTestServer(func(s *testServer, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		s.respond(w)
+	})
+	defer s.Close()
+
+	shared := newTestClient()
+	fetch(t, shared, "GET", s.URL)
+	if _, status := fetch(t, shared, "GET", s.URL); status != Miss {
+		t.Errorf("Expected a shared cache not to store private responses, got %s", status)
+	}
+
+	private := &http.Client{Transport: NewTransport(cache.NewInMemoryCache(time.Hour), TransportOpts{Private: true})}
+	fetch(t, private, "GET", s.URL)
+	if _, status := fetch(t, private, "GET", s.URL); status != Hit {
+		t.Errorf("Expected a private cache to store private responses, got %s", status)
+	}
+}
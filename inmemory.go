@@ -5,7 +5,9 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
+	"reflect"
 	"time"
 
 	"sync"
@@ -14,29 +16,245 @@ import (
 )
 
 type InMemoryCache struct {
-	cache             cache.Cache   // Only expose the methods we want to make available
-	mu                sync.RWMutex  // For increment / decrement prevent reads and writes
-	defaultExpiration time.Duration // DefaultExpiration.
+	cache                cache.Cache   // Only expose the methods we want to make available
+	mu                   *sync.RWMutex // For increment / decrement prevent reads and writes
+	defaultExpiration    time.Duration // DefaultExpiration.
+	negativeExpiration   time.Duration
+	compression          Compression
+	compressionThreshold int
+	lockRetries          int
+	lockBackoff          Backoff
+	logger               Logger
+	events               *eventHandlers
+	deleting             *sync.Map // Keys being deleted, to tell deletions from expirations.
+	clock                Clock
+	swept                *time.Time // When expired items were last deleted, by clock.
+}
+
+// janitorInterval is how often expired items are deleted.
+const janitorInterval = time.Minute
+
+type InMemoryOpts struct {
+	Expiration           time.Duration
+	NegativeExpiration   time.Duration
+	Compression          Compression
+	CompressionThreshold int
+	LockRetries          int
+	LockBackoff          Backoff
+	// Logger receives the errors of periodic snapshots.
+	Logger Logger
+	// Clock decides when items expire. Defaults to the system clock.
+	Clock Clock
+}
+
+func (o InMemoryOpts) padDefaults() InMemoryOpts {
+	if o.NegativeExpiration == 0 {
+		o.NegativeExpiration = defaultNegativeExpiration
+	}
+
+	if o.CompressionThreshold == 0 {
+		o.CompressionThreshold = defaultCompressionThreshold
+	}
+
+	if o.LockRetries == 0 {
+		o.LockRetries = defaultLockRetries
+	}
+
+	if o.LockBackoff == nil {
+		o.LockBackoff = defaultLockBackoff
+	}
+
+	if o.Clock == nil {
+		o.Clock = SystemClock{}
+	}
+
+	o.Logger = loggerOrNop(o.Logger)
+	return o
 }
 
 func NewInMemoryCache(defaultExpiration time.Duration) InMemoryCache {
-	return InMemoryCache{
-		cache:             *cache.New(defaultExpiration, time.Minute),
-		mu:                sync.RWMutex{},
-		defaultExpiration: defaultExpiration,
+	return NewInMemoryCacheWithOpts(InMemoryOpts{Expiration: defaultExpiration})
+}
+
+// NewInMemoryCacheWithOpts returns a new InMemoryCache with given parameters.
+func NewInMemoryCacheWithOpts(opts InMemoryOpts) InMemoryCache {
+	opts = opts.padDefaults()
+	c := InMemoryCache{
+		cache:                *cache.New(opts.Expiration, janitorInterval),
+		mu:                   &sync.RWMutex{},
+		defaultExpiration:    opts.Expiration,
+		negativeExpiration:   opts.NegativeExpiration,
+		compression:          opts.Compression,
+		compressionThreshold: opts.CompressionThreshold,
+		lockRetries:          opts.LockRetries,
+		lockBackoff:          opts.LockBackoff,
+		logger:               opts.Logger,
+		events:               &eventHandlers{},
+		deleting:             &sync.Map{},
+		clock:                opts.Clock,
 	}
+
+	swept := opts.Clock.Now()
+	c.swept = &swept
+	c.cache.OnEvicted(c.evicted)
+	return c
+}
+
+// evicted is called by go-cache when a key is deleted, or removed by its
+// janitor once expired.
+func (c InMemoryCache) evicted(key string, value interface{}) {
+	if isLockKey(key) {
+		return
+	}
+
+	if _, ok := c.deleting.Load(key); ok {
+		c.events.emit(EventDelete, key)
+		return
+	}
+	c.events.emit(EventExpire, key)
+}
+
+// OnEvent implements EventSource. Expired keys are only reported once they
+// are looked up, or once the janitor, running every minute, removes them. With
+// a Clock other than the system one, expired items are removed as others are
+// stored, at most once a minute of that clock.
+// Items are never evicted.
+func (c InMemoryCache) OnEvent(fn func(Event)) {
+	c.events.add(fn)
+}
+
+// remove deletes key, reporting it as deleted rather than expired.
+func (c InMemoryCache) remove(key string) {
+	c.deleting.Store(key, struct{}{})
+	c.cache.Delete(key)
+	c.deleting.Delete(key)
+}
+
+// inMemoryItem is what is stored in go-cache. Its expiration is kept with it
+// to be checked against the clock of the cache, which go-cache knows nothing
+// of.
+type inMemoryItem struct {
+	value interface{}
+	// expiration is when the item expires, in Unix nanoseconds. Zero means
+	// never.
+	expiration int64
+}
+
+func (i inMemoryItem) expired(now time.Time) bool {
+	return i.expiration != 0 && now.UnixNano() > i.expiration
+}
+
+// get returns the value stored at key, unless it is missing or has expired.
+// Expired items are deleted, which reports them as expired.
+func (c InMemoryCache) get(key string) (interface{}, bool) {
+	v, found := c.cache.Get(key)
+	if !found {
+		return nil, false
+	}
+
+	item := v.(inMemoryItem)
+	if item.expired(c.clock.Now()) {
+		c.cache.Delete(key)
+		return nil, false
+	}
+	return item.value, true
+}
+
+// set stores value at key for expires.
+func (c InMemoryCache) set(key string, value interface{}, expires time.Duration) {
+	if expires == DefaultExpiryTime {
+		expires = c.defaultExpiration
+	}
+
+	item := inMemoryItem{value: value}
+	if expires > 0 {
+		item.expiration = c.clock.Now().Add(expires).UnixNano()
+	}
+
+	// go-cache only expires items by the system clock. With any other, its
+	// janitor would remove items which have not expired yet, so they are
+	// swept here instead.
+	if _, ok := c.clock.(SystemClock); !ok {
+		expires = ForEverNeverExpiry
+		c.sweep()
+	}
+	c.cache.Set(key, item, expires)
+}
+
+// sweep deletes the expired items, at most once per janitorInterval of the
+// clock of the cache, which reports them as expired. The caller holds c.mu.
+func (c InMemoryCache) sweep() {
+	now := c.clock.Now()
+	if now.Sub(*c.swept) < janitorInterval {
+		return
+	}
+
+	*c.swept = now
+	for key, v := range c.cache.Items() {
+		if v.Object.(inMemoryItem).expired(now) {
+			c.cache.Delete(key)
+		}
+	}
+}
+
+// add stores value at key for expires, unless a live item is there. The
+// caller holds c.mu.
+func (c InMemoryCache) add(key string, value interface{}, expires time.Duration) bool {
+	if _, found := c.get(key); found {
+		return false
+	}
+
+	c.set(key, value, expires)
+	return true
+}
+
+// compressedValue is stored in place of values large enough to be compressed.
+type compressedValue []byte
+
+// pack turns value into what is stored, compressing it if asked to.
+func (c InMemoryCache) pack(value interface{}) (interface{}, error) {
+	if c.compression == NoCompression {
+		return value, nil
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < c.compressionThreshold {
+		return json.RawMessage(b), nil
+	}
+
+	b, err = compress(c.compression, c.compressionThreshold, b)
+	if err != nil {
+		return nil, err
+	}
+	return compressedValue(b), nil
+}
+
+// unpack returns the JSON encoding of a stored value.
+func unpack(value interface{}) ([]byte, error) {
+	if b, ok := value.(compressedValue); ok {
+		return decompress(b)
+	}
+	return json.Marshal(value)
 }
 
 func (c InMemoryCache) Get(key string, ptrValue interface{}) error {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	value, found := c.cache.Get(key)
+	value, found := c.get(key)
 	if !found {
 		return ErrCacheMiss
 	}
 
-	bytes, err := json.Marshal(value)
+	if _, ok := value.(tombstone); ok {
+		return ErrNegativeHit
+	}
+
+	bytes, err := unpack(value)
 	if err != nil {
 		return err
 	}
@@ -49,16 +267,20 @@ func (c InMemoryCache) GetMulti(keys ...string) (Getter, error) {
 }
 
 func (c InMemoryCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	existing := map[string]interface{}{}
-	v, found := c.cache.Get(key)
+	v, found := c.get(key)
 	if !found {
 		return ErrNotStored
 	}
 
-	bytes, err := json.Marshal(v)
+	if _, ok := v.(tombstone); ok {
+		return ErrNotStored
+	}
+
+	bytes, err := unpack(v)
 	if err != nil {
 		return err
 	}
@@ -71,34 +293,134 @@ func (c InMemoryCache) SetFields(key string, value map[string]interface{}, expir
 		existing[k] = v
 	}
 
-	c.cache.Set(key, existing, expires)
+	packed, err := c.pack(existing)
+	if err != nil {
+		return err
+	}
+
+	c.set(key, packed, expires)
+	c.events.emit(EventSet, key)
 	return nil
 }
 
 func (c InMemoryCache) Set(key string, value interface{}, expires time.Duration) error {
+	packed, err := c.pack(value)
+	if err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	// NOTE: go-cache understands the values of DefaultExpiryTime and ForEverNeverExpiry
-	c.cache.Set(key, value, expires)
+	c.set(key, packed, expires)
+	c.events.emit(EventSet, key)
 	return nil
 }
 
-func (c InMemoryCache) Add(key string, value interface{}, expires time.Duration) error {
+// Update decodes the value stored at key into ptrValue and calls fn, which
+// may change it. If fn returns true, the value left in ptrValue is stored at
+// key for expires. It all happens under the lock of the cache, so concurrent
+// updates of key are not lost. fn is told whether a value was found: a missing
+// or negative key leaves ptrValue as it was.
+func (c InMemoryCache) Update(key string, ptrValue interface{}, expires time.Duration, fn func(found bool) (bool, error)) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	err := c.cache.Add(key, value, expires)
+
+	v, found := c.get(key)
+	if _, ok := v.(tombstone); ok {
+		found = false
+	}
+
+	if found {
+		bytes, err := unpack(v)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(bytes, ptrValue); err != nil {
+			return err
+		}
+	}
+
+	store, err := fn(found)
+	if err != nil || !store {
+		return err
+	}
+
+	// Store a copy, not the pointer the caller goes on holding.
+	packed, err := c.pack(reflect.ValueOf(ptrValue).Elem().Interface())
 	if err != nil {
+		return err
+	}
+
+	c.set(key, packed, expires)
+	c.events.emit(EventSet, key)
+	return nil
+}
+
+// tombstone is stored in place of the value of keys known to be missing.
+type tombstone struct{}
+
+func (c InMemoryCache) SetNegative(key string, expires time.Duration) error {
+	if expires == DefaultExpiryTime {
+		expires = c.negativeExpiration
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, tombstone{}, expires)
+	c.events.emit(EventSet, key)
+	return nil
+}
+
+// Touch implements Toucher.
+func (c InMemoryCache) Touch(key string, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, found := c.get(key)
+	if !found {
 		return ErrNotStored
 	}
-	return err
+
+	if _, ok := v.(tombstone); ok {
+		return ErrNotStored
+	}
+
+	c.set(key, v, expires)
+	return nil
+}
+
+func (c InMemoryCache) Add(key string, value interface{}, expires time.Duration) error {
+	packed, err := c.pack(value)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.add(key, packed, expires) {
+		return ErrNotStored
+	}
+
+	c.events.emit(EventSet, key)
+	return nil
 }
 
 func (c InMemoryCache) Replace(key string, value interface{}, expires time.Duration) error {
+	packed, err := c.pack(value)
+	if err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if err := c.cache.Replace(key, value, expires); err != nil {
+	if _, found := c.get(key); !found {
 		return ErrNotStored
 	}
+
+	c.set(key, packed, expires)
+
+	c.events.emit(EventSet, key)
 	return nil
 }
 
@@ -109,9 +431,12 @@ func (c InMemoryCache) Keys() ([]string, error) {
 		return c.cache.Items()
 	}()
 
+	now := c.clock.Now()
 	keys := make([]string, 0, len(items))
-	for k := range items {
-		keys = append(keys, k)
+	for k, item := range items {
+		if !item.Object.(inMemoryItem).expired(now) {
+			keys = append(keys, k)
+		}
 	}
 
 	return keys, nil
@@ -120,7 +445,7 @@ func (c InMemoryCache) Keys() ([]string, error) {
 func (c InMemoryCache) Delete(key string) error {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	c.cache.Delete(key)
+	c.remove(key)
 	return nil
 }
 
@@ -129,5 +454,69 @@ func (c InMemoryCache) Flush() error {
 	defer c.mu.Unlock()
 
 	c.cache.Flush()
+	c.events.emit(EventFlush, "")
+	return nil
+}
+
+// Lock implements Locker. The lock lives in the cache itself, so it only
+// excludes other users of the same InMemoryCache.
+func (c InMemoryCache) Lock(ctx context.Context, key string, lease time.Duration) (Lock, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = obtainLock(ctx, c.lockRetries, c.lockBackoff, func() (bool, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.add(lockKey(key), token, lease), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &inMemoryLock{c: c, key: key, token: token}, nil
+}
+
+type inMemoryLock struct {
+	c     InMemoryCache
+	key   string
+	token string
+}
+
+func (l *inMemoryLock) Key() string {
+	return l.key
+}
+
+func (l *inMemoryLock) Token() string {
+	return l.token
+}
+
+// held reports whether the lock is still ours. The caller holds c.mu.
+func (l *inMemoryLock) held() bool {
+	v, found := l.c.get(lockKey(l.key))
+	return found && v == l.token
+}
+
+func (l *inMemoryLock) Refresh(ctx context.Context, lease time.Duration) error {
+	l.c.mu.Lock()
+	defer l.c.mu.Unlock()
+
+	if !l.held() {
+		return ErrLockNotHeld
+	}
+
+	l.c.set(lockKey(l.key), l.token, lease)
+	return nil
+}
+
+func (l *inMemoryLock) Unlock(ctx context.Context) error {
+	l.c.mu.Lock()
+	defer l.c.mu.Unlock()
+
+	if !l.held() {
+		return ErrLockNotHeld
+	}
+
+	l.c.remove(lockKey(l.key))
 	return nil
 }
@@ -5,12 +5,13 @@
 package cache
 
 import (
+	"sync"
 	"testing"
 	"time"
 )
 
 var newInMemoryCache = func(_ *testing.T, defaultExpiration time.Duration) Cache {
-	return NewInMemoryCache(defaultExpiration)
+	return NewInMemoryCacheWithOpts(InMemoryOpts{Expiration: defaultExpiration, Clock: newFakeClock()})
 }
 
 // Test typical cache interactions
@@ -45,3 +46,150 @@ func TestInMemoryCache_GetMulti(t *testing.T) {
 func TestInMemoryCache_Keys(t *testing.T) {
 	testKeys(t, newInMemoryCache)
 }
+
+func TestInMemoryCache_Negative(t *testing.T) {
+	testNegative(t, newInMemoryCache)
+}
+
+func TestInMemoryCache_ConcurrentSetFields(t *testing.T) {
+	testConcurrentSetFields(t, newInMemoryCache)
+}
+
+func TestInMemoryCache_Touch(t *testing.T) {
+	testTouch(t, newInMemoryCache)
+}
+
+func TestInMemoryCache_ClockSweep(t *testing.T) {
+	clock := newFakeClock()
+	c := NewInMemoryCacheWithOpts(InMemoryOpts{Clock: clock})
+	r := &eventRecorder{}
+	c.OnEvent(r.record)
+
+	c.Set("short", "a", time.Second)
+	c.Set("forever", "b", ForEverNeverExpiry)
+
+	// Expired items are deleted as others are stored, though never looked up.
+	clock.Advance(2 * time.Minute)
+	c.Set("other", "c", time.Hour)
+	if n := c.cache.ItemCount(); n != 2 {
+		t.Errorf("Expected the short item to be deleted, got %d items", n)
+	}
+
+	expectEvents(t, r,
+		Event{Type: EventSet, Key: "short"},
+		Event{Type: EventSet, Key: "forever"},
+		Event{Type: EventExpire, Key: "short"},
+		Event{Type: EventSet, Key: "other"},
+	)
+}
+
+func TestInMemoryCache_Update(t *testing.T) {
+	c := NewInMemoryCacheWithOpts(InMemoryOpts{Expiration: time.Hour, Compression: GzipCompression, CompressionThreshold: 1})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n int
+			if err := c.Update("n", &n, time.Hour, func(bool) (bool, error) {
+				n++
+				return true, nil
+			}); err != nil {
+				t.Errorf("Error updating: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var n int
+	if err := c.Get("n", &n); err != nil || n != 50 {
+		t.Errorf("Expected no update to be lost, got %v / %d", err, n)
+	}
+
+	// Negative keys are updated as missing ones, and nothing is stored unless
+	// asked.
+	c.SetNegative("missing", time.Hour)
+	if err := c.Update("missing", &n, time.Hour, func(found bool) (bool, error) {
+		if found {
+			t.Errorf("Expected a negative key not to be found")
+		}
+		return false, nil
+	}); err != nil {
+		t.Errorf("Error updating: %s", err)
+	}
+
+	if err := c.Get("missing", &n); err != ErrNegativeHit {
+		t.Errorf("Expected the negative entry to be left, got %v", err)
+	}
+}
+
+var newCompressedInMemoryCache = func(_ *testing.T, defaultExpiration time.Duration) Cache {
+	return NewInMemoryCacheWithOpts(InMemoryOpts{
+		Expiration:           defaultExpiration,
+		Compression:          GzipCompression,
+		CompressionThreshold: 1,
+		Clock:                newFakeClock(),
+	})
+}
+
+func TestInMemoryCache_CompressedTypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newCompressedInMemoryCache)
+}
+
+func TestInMemoryCache_CompressedSetFields(t *testing.T) {
+	testSetFields(t, newCompressedInMemoryCache)
+}
+
+func TestInMemoryCache_CompressedGetMulti(t *testing.T) {
+	testGetMulti(t, newCompressedInMemoryCache)
+}
+
+var newInMemoryLocker = func(_ *testing.T) Locker {
+	return NewInMemoryCacheWithOpts(InMemoryOpts{LockRetries: 1, Clock: newFakeClock()})
+}
+
+func TestInMemoryCache_Lock(t *testing.T) {
+	testLock(t, newInMemoryLocker)
+}
+
+func TestInMemoryCache_LockExpiry(t *testing.T) {
+	testLockExpiry(t, newInMemoryLocker)
+}
+
+func TestInMemoryCache_LockRefresh(t *testing.T) {
+	testLockRefresh(t, newInMemoryLocker)
+}
+
+func TestInMemoryCache_Clock(t *testing.T) {
+	clock := newFakeClock()
+	c := NewInMemoryCacheWithOpts(InMemoryOpts{Expiration: time.Minute, Clock: clock})
+	r := &eventRecorder{}
+	c.OnEvent(r.record)
+
+	c.Set("default", "a", DefaultExpiryTime)
+	c.Set("short", "b", time.Second)
+	c.Set("forever", "c", ForEverNeverExpiry)
+
+	clock.Advance(2 * time.Second)
+	if keys, _ := c.Keys(); len(keys) != 2 {
+		t.Errorf("Expected the short item to be gone, got %v", keys)
+	}
+
+	clock.Advance(time.Hour)
+	var value string
+	if err := c.Get("default", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the default expiration to apply, got %v", err)
+	}
+
+	if err := c.Get("forever", &value); err != nil || value != "c" {
+		t.Errorf("Error getting an item which never expires: %v / %s", err, value)
+	}
+
+	expectEvents(t, r,
+		Event{Type: EventSet, Key: "default"},
+		Event{Type: EventSet, Key: "short"},
+		Event{Type: EventSet, Key: "forever"},
+		Event{Type: EventExpire, Key: "default"},
+	)
+}
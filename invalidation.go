@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+// Invalidation announces that keys have changed and local copies of them
+// must be dropped.
+type Invalidation struct {
+	// Source identifies the publisher, so it can skip its own messages.
+	Source string   `json:"source"`
+	Keys   []string `json:"keys,omitempty"`
+	// Flush means every key has changed.
+	Flush bool `json:"flush,omitempty"`
+}
+
+// InvalidationBus carries Invalidations between processes sharing a remote
+// cache.
+type InvalidationBus interface {
+	// Publish sends the invalidation to every subscriber, including the ones
+	// in this process.
+	Publish(inv Invalidation) error
+
+	// Subscribe calls fn with every invalidation published on the bus until
+	// the bus is closed.
+	Subscribe(fn func(Invalidation))
+
+	// Close stops delivering invalidations.
+	Close() error
+}
+
+type invalidationSubscribers struct {
+	mu  sync.RWMutex
+	fns []func(Invalidation)
+}
+
+func (s *invalidationSubscribers) add(fn func(Invalidation)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fns = append(s.fns, fn)
+}
+
+func (s *invalidationSubscribers) notify(inv Invalidation) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, fn := range s.fns {
+		fn(inv)
+	}
+}
+
+func (s *invalidationSubscribers) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fns = nil
+}
+
+// InMemoryBus is an InvalidationBus within a single process, delivering
+// invalidations synchronously. It is mostly useful for tests.
+type InMemoryBus struct {
+	subscribers invalidationSubscribers
+}
+
+func NewInMemoryBus() *InMemoryBus {
+	return &InMemoryBus{}
+}
+
+func (b *InMemoryBus) Publish(inv Invalidation) error {
+	b.subscribers.notify(inv)
+	return nil
+}
+
+func (b *InMemoryBus) Subscribe(fn func(Invalidation)) {
+	b.subscribers.add(fn)
+}
+
+func (b *InMemoryBus) Close() error {
+	b.subscribers.reset()
+	return nil
+}
+
+// RedisBus is an InvalidationBus on top of a Redis pub/sub channel.
+type RedisBus struct {
+	pool        *redis.Client
+	pubsub      *redis.PubSub
+	channel     string
+	subscribers invalidationSubscribers
+	logger      Logger
+	done        chan struct{}
+}
+
+// NewRedisBus subscribes to channel on the server of c and returns a bus
+// publishing on it. Messages which are not invalidations are reported to the
+// Logger of c.
+func NewRedisBus(c *RedisCache, channel string) (*RedisBus, error) {
+	pubsub := c.pool.Subscribe(channel)
+
+	// Wait for the subscription to be confirmed, so that nothing published
+	// after we return is missed.
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	b := &RedisBus{
+		pool:    c.pool,
+		pubsub:  pubsub,
+		channel: channel,
+		logger:  c.logger,
+		done:    make(chan struct{}),
+	}
+
+	go b.receive()
+	return b, nil
+}
+
+func (b *RedisBus) receive() {
+	defer close(b.done)
+
+	for msg := range b.pubsub.Channel() {
+		var inv Invalidation
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			b.logger.Warn("cache: skipping an undecodable invalidation", "channel", b.channel, "error", err)
+			continue
+		}
+
+		b.subscribers.notify(inv)
+	}
+}
+
+func (b *RedisBus) Publish(inv Invalidation) error {
+	payload, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return b.pool.Publish(b.channel, payload).Err()
+}
+
+func (b *RedisBus) Subscribe(fn func(Invalidation)) {
+	b.subscribers.add(fn)
+}
+
+func (b *RedisBus) Close() error {
+	err := b.pubsub.Close()
+	<-b.done
+	return err
+}
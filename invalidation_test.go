@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// eventually retries cond until it holds or a second has passed.
+func eventually(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// testInvalidation checks that two near caches over the same remote cache,
+// connected through their buses, never serve each other's stale values.
+func testInvalidation(t *testing.T, remote Cache, busA, busB InvalidationBus) {
+	a := NewTieredCache(NewInMemoryCache(time.Hour), remote, time.Hour)
+	if err := a.UseInvalidationBus(busA); err != nil {
+		t.Fatalf("Error using bus: %s", err)
+	}
+
+	b := NewTieredCache(NewInMemoryCache(time.Hour), remote, time.Hour)
+	if err := b.UseInvalidationBus(busB); err != nil {
+		t.Fatalf("Error using bus: %s", err)
+	}
+
+	reads := func(c Cache, expected string) func() bool {
+		return func() bool {
+			var value string
+			err := c.Get("value", &value)
+			if expected == "" {
+				return err == ErrCacheMiss
+			}
+			return err == nil && value == expected
+		}
+	}
+
+	if err := a.Set("value", "foo", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	// Let b keep a local copy, then overwrite it through a.
+	if !reads(b, "foo")() {
+		t.Fatalf("Expected b to read foo")
+	}
+
+	if err := a.Set("value", "bar", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	if !eventually(reads(b, "bar")) {
+		t.Errorf("Expected b to drop its copy after a Set")
+	}
+
+	if err := b.Delete("value"); err != nil {
+		t.Fatalf("Error deleting a value: %s", err)
+	}
+
+	if !eventually(reads(a, "")) {
+		t.Errorf("Expected a to drop its copy after a Delete")
+	}
+
+	if err := a.Set("value", "baz", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	if !eventually(reads(b, "baz")) {
+		t.Fatalf("Expected b to read baz")
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+
+	if !eventually(reads(b, "")) {
+		t.Errorf("Expected b to drop its copies after a Flush")
+	}
+}
+
+func TestInMemoryBus_Invalidation(t *testing.T) {
+	bus := NewInMemoryBus()
+	defer bus.Close()
+
+	testInvalidation(t, NewInMemoryCache(time.Hour), bus, bus)
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	mathrand "math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLockRetries = 5
+	defaultLockLease   = 5 * time.Second
+)
+
+// Locker hands out exclusive, leased locks on keys.
+//
+// A lock is held until it is released with Unlock or until its lease runs
+// out, whichever comes first. Every lock carries a random token and only the
+// holder of that token can refresh or release it, so a holder whose lease has
+// expired can never release a lock that has since been taken by someone else.
+type Locker interface {
+	// Lock acquires the lock on key for the given lease, retrying with the
+	// configured backoff while it is held by someone else.
+	//
+	// Returns:
+	//   - the held Lock, and a nil error on success
+	//   - ErrLockNotObtained if the lock was still held after all retries
+	//   - the context error if ctx was done while waiting
+	//   - an implementation specific error otherwise
+	Lock(ctx context.Context, key string, lease time.Duration) (Lock, error)
+}
+
+// Lock is a lock obtained from a Locker.
+type Lock interface {
+	// Key returns the key the lock was taken on.
+	Key() string
+
+	// Token returns the random token identifying this holder of the lock.
+	Token() string
+
+	// Refresh extends the lease of the lock to the given duration.
+	//
+	// Returns:
+	//   - nil if the lease was extended
+	//   - ErrLockNotHeld if the lease has expired or the lock was taken over
+	//   - an implementation specific error otherwise
+	Refresh(ctx context.Context, lease time.Duration) error
+
+	// Unlock releases the lock.
+	//
+	// Returns:
+	//   - nil if the lock was released
+	//   - ErrLockNotHeld if the lease has expired or the lock was taken over
+	//   - an implementation specific error otherwise
+	Unlock(ctx context.Context) error
+}
+
+// Backoff returns how long to wait before the given retry, starting at 1.
+type Backoff func(retry int) time.Duration
+
+// ConstantBackoff waits the same duration before every retry.
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// ExponentialBackoff doubles the wait before every retry, starting at base
+// and never exceeding max.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(retry int) time.Duration {
+		d := base
+		for i := 1; i < retry && d < max; i++ {
+			d *= 2
+		}
+
+		if d > max {
+			return max
+		}
+		return d
+	}
+}
+
+// JitteredBackoff waits a random duration between half and all of what b
+// says, so that callers failing together do not retry in lockstep.
+func JitteredBackoff(b Backoff) Backoff {
+	return func(retry int) time.Duration {
+		d := b(retry)
+		if d <= 1 {
+			return d
+		}
+		return d/2 + time.Duration(mathrand.Int63n(int64(d/2)+1))
+	}
+}
+
+var defaultLockBackoff = JitteredBackoff(ExponentialBackoff(50*time.Millisecond, time.Second))
+
+// lockKeyPrefix starts the keys holding locks. Keys of values do not start
+// with a NUL byte, so they cannot clash with them.
+const lockKeyPrefix = "\x00lock:"
+
+// lockKey is where the lock on key is stored. RedisCache takes the same lock
+// in Add, Replace and SetFields, so holding it also excludes those operations.
+func lockKey(key string) string {
+	return lockKeyPrefix + key
+}
+
+// isLockKey reports whether key holds a lock rather than a value.
+func isLockKey(key string) bool {
+	return strings.HasPrefix(key, lockKeyPrefix)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// obtainLock calls try up to retries times, sleeping as told by backoff in
+// between, until it reports that the lock was taken.
+func obtainLock(ctx context.Context, retries int, backoff Backoff, try func() (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		ok, err := try()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			return nil
+		}
+
+		if attempt >= retries {
+			return ErrLockNotObtained
+		}
+
+		t := time.NewTimer(backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Tests against a generic Locker interface.
+// They should pass for all implementations.
+type lockerFactory func(*testing.T) Locker
+
+const testLockLease = 500 * time.Millisecond
+
+func testLock(t *testing.T, newLocker lockerFactory) {
+	ctx := context.Background()
+	locker := newLocker(t)
+
+	l, err := locker.Lock(ctx, "lock", time.Minute)
+	if err != nil {
+		t.Fatalf("Error taking a free lock: %s", err)
+	}
+
+	if l.Key() != "lock" {
+		t.Errorf("Expected key lock, got %s", l.Key())
+	}
+
+	if _, err = locker.Lock(ctx, "lock", time.Minute); err != ErrLockNotObtained {
+		t.Errorf("Expected ErrLockNotObtained on a held lock, got: %v", err)
+	}
+
+	if _, err = locker.Lock(ctx, "other", time.Minute); err != nil {
+		t.Errorf("Error taking a different lock: %s", err)
+	}
+
+	if err = l.Unlock(ctx); err != nil {
+		t.Errorf("Error releasing a held lock: %s", err)
+	}
+
+	if err = l.Unlock(ctx); err != ErrLockNotHeld {
+		t.Errorf("Expected ErrLockNotHeld releasing twice, got: %v", err)
+	}
+
+	if _, err = locker.Lock(ctx, "lock", time.Minute); err != nil {
+		t.Errorf("Error taking a released lock: %s", err)
+	}
+}
+
+func testLockExpiry(t *testing.T, newLocker lockerFactory) {
+	ctx := context.Background()
+	locker := newLocker(t)
+
+	stale, err := locker.Lock(ctx, "lock", testLockLease)
+	if err != nil {
+		t.Fatalf("Error taking a free lock: %s", err)
+	}
+
+	// Wait for the lease to run out and let someone else take the lock.
+	wait(locker, 2*testLockLease)
+	l, err := locker.Lock(ctx, "lock", time.Minute)
+	if err != nil {
+		t.Fatalf("Error taking an expired lock: %s", err)
+	}
+
+	if stale.Token() == l.Token() {
+		t.Errorf("Expected a new token, got %s twice", l.Token())
+	}
+
+	// The previous holder must not be able to touch the new lock.
+	if err = stale.Refresh(ctx, time.Minute); err != ErrLockNotHeld {
+		t.Errorf("Expected ErrLockNotHeld refreshing a stale lock, got: %v", err)
+	}
+
+	if err = stale.Unlock(ctx); err != ErrLockNotHeld {
+		t.Errorf("Expected ErrLockNotHeld releasing a stale lock, got: %v", err)
+	}
+
+	if _, err = locker.Lock(ctx, "lock", time.Minute); err != ErrLockNotObtained {
+		t.Errorf("Expected the lock to still be held, got: %v", err)
+	}
+
+	if err = l.Unlock(ctx); err != nil {
+		t.Errorf("Error releasing a held lock: %s", err)
+	}
+}
+
+func testLockRefresh(t *testing.T, newLocker lockerFactory) {
+	ctx := context.Background()
+	locker := newLocker(t)
+
+	l, err := locker.Lock(ctx, "lock", testLockLease)
+	if err != nil {
+		t.Fatalf("Error taking a free lock: %s", err)
+	}
+
+	if err = l.Refresh(ctx, time.Minute); err != nil {
+		t.Errorf("Error refreshing a held lock: %s", err)
+	}
+
+	wait(locker, 2*testLockLease)
+	if _, err = locker.Lock(ctx, "lock", time.Minute); err != ErrLockNotObtained {
+		t.Errorf("Expected a refreshed lock to still be held, got: %v", err)
+	}
+
+	if err = l.Unlock(ctx); err != nil {
+		t.Errorf("Error releasing a refreshed lock: %s", err)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for ix, d := range expected {
+		if got := backoff(ix + 1); got != d*time.Millisecond {
+			t.Errorf("Retry %d: expected %s, got %s", ix+1, d*time.Millisecond, got)
+		}
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	backoff := JitteredBackoff(ConstantBackoff(100 * time.Millisecond))
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		d := backoff(1)
+		if d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("Expected a wait between 50ms and 100ms, got %s", d)
+		}
+		seen[d] = true
+	}
+
+	if len(seen) < 2 {
+		t.Errorf("Expected waits to vary")
+	}
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Logger receives the errors that callers of a cache are not expected to
+// check, as a message followed by alternating keys and values. A
+// *slog.Logger implements it.
+type Logger interface {
+	Error(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Error(string, ...interface{}) {}
+func (nopLogger) Warn(string, ...interface{})  {}
+
+// loggerOrNop returns l, or a Logger discarding everything if it is nil.
+func loggerOrNop(l Logger) Logger {
+	if l == nil {
+		return nopLogger{}
+	}
+	return l
+}
+
+// isDecodeError reports whether err comes from decoding a stored value.
+func isDecodeError(err error) bool {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	}
+	return false
+}
+
+// LogOpts configures a LoggingCache.
+type LogOpts struct {
+	Logger Logger
+	// Backend names the wrapped cache in the logs, such as "redis".
+	Backend string
+	// SlowThreshold logs the operations taking longer than it. Zero disables
+	// it.
+	SlowThreshold time.Duration
+}
+
+// LoggingCache wraps a Cache so that every error other than ErrCacheMiss,
+// ErrNotStored and ErrNegativeHit is logged, along with the operation, key and
+// backend, as the Cache interface promises. Values which cannot be decoded
+// and slow operations are logged too.
+type LoggingCache struct {
+	cache         Cache
+	logger        Logger
+	backend       string
+	slowThreshold time.Duration
+}
+
+// NewLoggingCache returns a LoggingCache logging the errors of c.
+func NewLoggingCache(c Cache, opts LogOpts) *LoggingCache {
+	return &LoggingCache{
+		cache:         c,
+		logger:        loggerOrNop(opts.Logger),
+		backend:       opts.Backend,
+		slowThreshold: opts.SlowThreshold,
+	}
+}
+
+// log reports how op on key went. It returns err, so that callers can
+// return through it.
+func (c *LoggingCache) log(op, key string, start time.Time, err error) error {
+	args := []interface{}{"backend", c.backend, "operation", op}
+	if key != "" {
+		args = append(args, "key", key)
+	}
+
+	if d := time.Since(start); c.slowThreshold > 0 && d > c.slowThreshold {
+		c.logger.Warn("cache: slow operation", append(args, "duration", d)...)
+	}
+
+	switch {
+	case err == nil, err == ErrCacheMiss, err == ErrNotStored, err == ErrNegativeHit:
+	case isDecodeError(err):
+		c.logger.Error("cache: cannot decode value", append(args, "error", err)...)
+	default:
+		c.logger.Error("cache: operation failed", append(args, "error", err)...)
+	}
+	return err
+}
+
+func (c *LoggingCache) Get(key string, ptrValue interface{}) error {
+	start := time.Now()
+	return c.log("get", key, start, c.cache.Get(key, ptrValue))
+}
+
+func (c *LoggingCache) GetMulti(keys ...string) (Getter, error) {
+	start := time.Now()
+	g, err := c.cache.GetMulti(keys...)
+	if err != nil {
+		return g, c.log("get_multi", "", start, err)
+	}
+
+	c.log("get_multi", "", start, nil)
+	return loggingGetter{c: c, g: g}, nil
+}
+
+func (c *LoggingCache) Set(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	return c.log("set", key, start, c.cache.Set(key, value, expires))
+}
+
+func (c *LoggingCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	start := time.Now()
+	return c.log("set_fields", key, start, c.cache.SetFields(key, value, expires))
+}
+
+func (c *LoggingCache) Add(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	return c.log("add", key, start, c.cache.Add(key, value, expires))
+}
+
+func (c *LoggingCache) Replace(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	return c.log("replace", key, start, c.cache.Replace(key, value, expires))
+}
+
+func (c *LoggingCache) Delete(key string) error {
+	start := time.Now()
+	return c.log("delete", key, start, c.cache.Delete(key))
+}
+
+func (c *LoggingCache) Flush() error {
+	start := time.Now()
+	return c.log("flush", "", start, c.cache.Flush())
+}
+
+func (c *LoggingCache) Keys() ([]string, error) {
+	start := time.Now()
+	keys, err := c.cache.Keys()
+	return keys, c.log("keys", "", start, err)
+}
+
+// loggingGetter implements a Getter logging the values fetched by GetMulti
+// which cannot be decoded.
+type loggingGetter struct {
+	c *LoggingCache
+	g Getter
+}
+
+func (g loggingGetter) Get(key string, ptrValue interface{}) error {
+	err := g.g.Get(key, ptrValue)
+	if isDecodeError(err) {
+		g.c.logger.Error("cache: cannot decode value", "backend", g.c.backend, "operation", "get_multi", "key", key, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ Logger = slog.Default()
+
+// recordingLogger keeps what it was told to log.
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *recordingLogger) log(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args) }
+
+func (l *recordingLogger) Entries() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.entries...)
+}
+
+// failingCache fails every write.
+type failingCache struct {
+	InMemoryCache
+	delay time.Duration
+}
+
+func (c failingCache) Set(key string, value interface{}, expires time.Duration) error {
+	time.Sleep(c.delay)
+	return errors.New("connection refused")
+}
+
+var newLoggingCache = func(t *testing.T, defaultExpiration time.Duration) Cache {
+	return NewLoggingCache(newInMemoryCache(t, defaultExpiration), LogOpts{})
+}
+
+func TestLoggingCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newLoggingCache)
+}
+
+func TestLoggingCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newLoggingCache)
+}
+
+func TestLoggingCache(t *testing.T) {
+	logger := &recordingLogger{}
+	backend := NewInMemoryCache(time.Hour)
+	c := NewLoggingCache(failingCache{InMemoryCache: backend, delay: 20 * time.Millisecond}, LogOpts{
+		Logger:        logger,
+		Backend:       "redis",
+		SlowThreshold: 10 * time.Millisecond,
+	})
+
+	var value int
+	c.Get("missing", &value)
+	c.Add("key", "foo", time.Hour)
+	c.Add("key", "foo", time.Hour)
+	if entries := logger.Entries(); len(entries) != 0 {
+		t.Errorf("Expected misses and not stored to be quiet, got %v", entries)
+	}
+
+	c.Get("key", &value)
+	g, _ := c.GetMulti("key")
+	g.Get("key", &value)
+	c.Set("key", 1, time.Hour)
+
+	expected := []string{
+		"ERROR cache: cannot decode value [backend redis operation get key key error json: cannot unmarshal string into Go value of type int]",
+		"ERROR cache: cannot decode value [backend redis operation get_multi key key error json: cannot unmarshal string into Go value of type int]",
+		"WARN cache: slow operation [backend redis operation set key key duration",
+		"ERROR cache: operation failed [backend redis operation set key key error connection refused]",
+	}
+
+	entries := logger.Entries()
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %v", len(expected), entries)
+	}
+
+	for i, prefix := range expected {
+		if len(entries[i]) < len(prefix) || entries[i][:len(prefix)] != prefix {
+			t.Errorf("Expected %q, got %q", prefix, entries[i])
+		}
+	}
+}
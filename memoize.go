@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// memoizeGenerationAttempts is how many times a generation is looked up,
+// each time failing to create it because someone else did, before giving up.
+const memoizeGenerationAttempts = 5
+
+// Memoized is a function whose results are cached, returned by Memoize.
+type Memoized[A, R any] struct {
+	cache Cache
+	name  string
+	ttl   time.Duration
+	fn    func(A) (R, error)
+	loads loadGroup
+}
+
+// Memoize returns fn with its results cached in c for ttl, under keys derived
+// from name and a hash of the JSON encoding of the arguments. Functions taking
+// several arguments take them as a struct.
+//
+// Concurrent calls with the same missing arguments share a single call to fn.
+// If c has a GetOrLoad, such as RevalidatingCache, results are loaded through
+// it instead, and its TTL applies. Errors are not cached.
+func Memoize[A, R any](c Cache, name string, ttl time.Duration, fn func(A) (R, error)) *Memoized[A, R] {
+	return &Memoized[A, R]{
+		cache: c,
+		name:  name,
+		ttl:   ttl,
+		fn:    fn,
+	}
+}
+
+// generationKey holds a token which is part of the key of every result, so
+// that changing it forgets them all.
+func (m *Memoized[A, R]) generationKey() string {
+	return m.name + ":generation"
+}
+
+// generation returns the current generation token, creating it if missing.
+// It returns ErrNoGeneration if the cache does not keep it long enough to be
+// read back.
+func (m *Memoized[A, R]) generation() (string, error) {
+	for attempt := 0; attempt < memoizeGenerationAttempts; attempt++ {
+		var gen string
+		err := m.cache.Get(m.generationKey(), &gen)
+		if err != ErrCacheMiss {
+			return gen, err
+		}
+
+		if gen, err = randomToken(); err != nil {
+			return "", err
+		}
+
+		// Someone else may have created it in the meantime.
+		err = m.cache.Add(m.generationKey(), gen, ForEverNeverExpiry)
+		if err != ErrNotStored {
+			return gen, err
+		}
+	}
+	return "", ErrNoGeneration
+}
+
+// key returns where the result of args is stored.
+func (m *Memoized[A, R]) key(args A) (string, error) {
+	gen, err := m.generation()
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return m.name + ":" + gen + ":" + hex.EncodeToString(sum[:16]), nil
+}
+
+// Call returns the result of fn for args, from the cache if it is there.
+func (m *Memoized[A, R]) Call(args A) (R, error) {
+	var result R
+	key, err := m.key(args)
+	if err != nil {
+		return result, err
+	}
+
+	load := func(string) (interface{}, error) {
+		return m.fn(args)
+	}
+
+	if loader, ok := m.cache.(interface {
+		GetOrLoad(key string, ptrValue interface{}, load Loader) error
+	}); ok {
+		err := loader.GetOrLoad(key, &result, load)
+		return result, err
+	}
+
+	if err := m.cache.Get(key, &result); err != ErrCacheMiss {
+		return result, err
+	}
+
+	b, err := m.loads.do(key, func() (json.RawMessage, error) {
+		value, err := load(key)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := m.cache.Set(key, json.RawMessage(b), m.ttl); err != nil {
+			return nil, err
+		}
+		return b, nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, json.Unmarshal(b, &result)
+}
+
+// Forget deletes the cached result for args.
+func (m *Memoized[A, R]) Forget(args A) error {
+	key, err := m.key(args)
+	if err != nil {
+		return err
+	}
+	return m.cache.Delete(key)
+}
+
+// ForgetAll forgets every cached result. They are left to expire.
+func (m *Memoized[A, R]) ForgetAll() error {
+	gen, err := randomToken()
+	if err != nil {
+		return err
+	}
+	return m.cache.Set(m.generationKey(), gen, ForEverNeverExpiry)
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type square struct {
+	N int
+}
+
+func newTestSquare(c Cache) (*Memoized[square, int], *int32) {
+	var calls int32
+	return Memoize(c, "square", time.Hour, func(args square) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return args.N * args.N, nil
+	}), &calls
+}
+
+func TestMemoize(t *testing.T) {
+	m, calls := newTestSquare(NewInMemoryCache(time.Hour))
+
+	for _, n := range []int{3, 3, 4} {
+		result, err := m.Call(square{N: n})
+		if err != nil || result != n*n {
+			t.Fatalf("Error calling with %d: %s / %d", n, err, result)
+		}
+	}
+
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("Expected one call per argument set, got %d", n)
+	}
+}
+
+func TestMemoize_Forget(t *testing.T) {
+	m, calls := newTestSquare(NewInMemoryCache(time.Hour))
+
+	m.Call(square{N: 3})
+	m.Call(square{N: 4})
+	if err := m.Forget(square{N: 3}); err != nil {
+		t.Fatalf("Error forgetting: %s", err)
+	}
+
+	m.Call(square{N: 3})
+	m.Call(square{N: 4})
+	if n := atomic.LoadInt32(calls); n != 3 {
+		t.Errorf("Expected only the forgotten result to be recomputed, got %d calls", n)
+	}
+
+	if err := m.ForgetAll(); err != nil {
+		t.Fatalf("Error forgetting all: %s", err)
+	}
+
+	m.Call(square{N: 3})
+	m.Call(square{N: 4})
+	if n := atomic.LoadInt32(calls); n != 5 {
+		t.Errorf("Expected every result to be recomputed, got %d calls", n)
+	}
+}
+
+func TestMemoize_Errors(t *testing.T) {
+	var calls int32
+	errFailed := errors.New("failed")
+	m := Memoize(NewInMemoryCache(time.Hour), "failing", time.Hour, func(string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "", errFailed
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := m.Call("a"); err != errFailed {
+			t.Errorf("Expected the error of the function, got %v", err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected errors not to be cached, got %d calls", n)
+	}
+}
+
+func TestMemoize_Deduplicates(t *testing.T) {
+	for name, c := range map[string]Cache{
+		"plain":        NewInMemoryCache(time.Hour),
+		"revalidating": NewRevalidatingCache(NewInMemoryCache(time.Hour), RevalidateOpts{TTL: time.Hour}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			release := make(chan struct{})
+			m := Memoize(c, "slow", time.Hour, func(n int) (int, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return n, nil
+			})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if result, err := m.Call(7); err != nil || result != 7 {
+						t.Errorf("Error calling: %s / %d", err, result)
+					}
+				}()
+			}
+
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if n := atomic.LoadInt32(&calls); n != 1 {
+				t.Errorf("Expected concurrent calls to share one, got %d", n)
+			}
+		})
+	}
+}
+
+// forgetfulCache never keeps what is added to it, as if it were evicted
+// right away.
+type forgetfulCache struct {
+	InMemoryCache
+}
+
+func (forgetfulCache) Add(key string, value interface{}, expires time.Duration) error {
+	return ErrNotStored
+}
+
+func TestMemoize_NoGeneration(t *testing.T) {
+	m, calls := newTestSquare(forgetfulCache{NewInMemoryCache(time.Hour)})
+	if _, err := m.Call(square{2}); err != ErrNoGeneration {
+		t.Errorf("Expected ErrNoGeneration, got %v", err)
+	}
+
+	if n := atomic.LoadInt32(calls); n != 0 {
+		t.Errorf("Expected the function not to be called, got %d calls", n)
+	}
+}
@@ -0,0 +1,350 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency
+// histogram buckets used unless others are given to NewMetrics.
+var DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// Outcomes of an operation, as labelled in the metrics.
+const (
+	outcomeHit         = "hit"
+	outcomeMiss        = "miss"
+	outcomeNegativeHit = "negative_hit"
+	outcomeNotStored   = "not_stored"
+	outcomeOK          = "ok"
+	outcomeError       = "error"
+)
+
+// Metrics collects the metrics of caches instrumented through it, and exposes
+// them in the Prometheus text format, so that they can be scraped without
+// pulling in a Prometheus client library.
+//
+// For every cache and operation, it counts the calls by outcome (hit, miss,
+// negative_hit, not_stored, ok or error), and keeps a histogram of their
+// latency. For caches on top of a RedisCache, it also exposes the statistics
+// of its connection pool.
+type Metrics struct {
+	buckets []float64
+
+	mu    sync.Mutex
+	ops   map[opKey]*opMetrics
+	pools map[string]func() *redis.PoolStats
+}
+
+type opKey struct {
+	cache string
+	op    string
+}
+
+type opMetrics struct {
+	outcomes map[string]uint64
+	buckets  []uint64 // Calls by the first bucket they fit in.
+	sum      float64
+	count    uint64
+}
+
+// NewMetrics returns an empty Metrics, keeping latency histograms with the
+// given bucket upper bounds in seconds, or DefaultLatencyBuckets if none.
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Metrics{
+		buckets: buckets,
+		ops:     map[opKey]*opMetrics{},
+		pools:   map[string]func() *redis.PoolStats{},
+	}
+}
+
+// Instrument returns c recording its metrics under the given name.
+func (m *Metrics) Instrument(name string, c Cache) *InstrumentedCache {
+	if p, ok := c.(interface {
+		PoolStats() *redis.PoolStats
+	}); ok {
+		m.mu.Lock()
+		m.pools[name] = p.PoolStats
+		m.mu.Unlock()
+	}
+
+	return &InstrumentedCache{cache: c, name: name, metrics: m}
+}
+
+func (m *Metrics) observe(cache, op, outcome string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := opKey{cache: cache, op: op}
+	om, ok := m.ops[key]
+	if !ok {
+		om = &opMetrics{
+			outcomes: map[string]uint64{},
+			buckets:  make([]uint64, len(m.buckets)),
+		}
+		m.ops[key] = om
+	}
+
+	seconds := d.Seconds()
+	om.outcomes[outcome]++
+	om.sum += seconds
+	om.count++
+
+	if i := sort.SearchFloat64s(m.buckets, seconds); i < len(m.buckets) {
+		om.buckets[i]++
+	}
+}
+
+// snapshot copies the metrics, so that they can be written without holding
+// m.mu while the instrumented caches wait for it.
+func (m *Metrics) snapshot() (map[opKey]*opMetrics, map[string]func() *redis.PoolStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ops := make(map[opKey]*opMetrics, len(m.ops))
+	for key, om := range m.ops {
+		outcomes := make(map[string]uint64, len(om.outcomes))
+		for outcome, n := range om.outcomes {
+			outcomes[outcome] = n
+		}
+
+		ops[key] = &opMetrics{
+			outcomes: outcomes,
+			buckets:  append([]uint64(nil), om.buckets...),
+			sum:      om.sum,
+			count:    om.count,
+		}
+	}
+
+	pools := make(map[string]func() *redis.PoolStats, len(m.pools))
+	for name, stats := range m.pools {
+		pools[name] = stats
+	}
+	return ops, pools
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	ops, poolStats := m.snapshot()
+	keys := make([]opKey, 0, len(ops))
+	for key := range ops {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].cache != keys[j].cache {
+			return keys[i].cache < keys[j].cache
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	fmt.Fprintln(cw, "# HELP cache_operations_total Cache operations by outcome.")
+	fmt.Fprintln(cw, "# TYPE cache_operations_total counter")
+	for _, key := range keys {
+		om := ops[key]
+		outcomes := make([]string, 0, len(om.outcomes))
+		for outcome := range om.outcomes {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+
+		for _, outcome := range outcomes {
+			fmt.Fprintf(cw, "cache_operations_total{cache=%s,operation=%s,outcome=%s} %d\n",
+				quoteLabel(key.cache), quoteLabel(key.op), quoteLabel(outcome), om.outcomes[outcome])
+		}
+	}
+
+	fmt.Fprintln(cw, "# HELP cache_operation_duration_seconds Latency of cache operations.")
+	fmt.Fprintln(cw, "# TYPE cache_operation_duration_seconds histogram")
+	for _, key := range keys {
+		om := ops[key]
+		labels := "cache=" + quoteLabel(key.cache) + ",operation=" + quoteLabel(key.op)
+
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += om.buckets[i]
+			fmt.Fprintf(cw, "cache_operation_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(cw, "cache_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, om.count)
+		fmt.Fprintf(cw, "cache_operation_duration_seconds_sum{%s} %s\n", labels, formatFloat(om.sum))
+		fmt.Fprintf(cw, "cache_operation_duration_seconds_count{%s} %d\n", labels, om.count)
+	}
+
+	names := make([]string, 0, len(poolStats))
+	for name := range poolStats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pools := make([]*redis.PoolStats, len(names))
+	for i, name := range names {
+		pools[i] = poolStats[name]()
+	}
+
+	if len(names) > 0 {
+		for _, metric := range []struct {
+			name, kind, help string
+			value            func(*redis.PoolStats) uint32
+		}{
+			{"cache_redis_pool_hits_total", "counter", "Times a free connection was found in the pool.", func(s *redis.PoolStats) uint32 { return s.Hits }},
+			{"cache_redis_pool_misses_total", "counter", "Times a free connection was not found in the pool.", func(s *redis.PoolStats) uint32 { return s.Misses }},
+			{"cache_redis_pool_timeouts_total", "counter", "Times waiting for a connection timed out.", func(s *redis.PoolStats) uint32 { return s.Timeouts }},
+			{"cache_redis_pool_stale_connections_total", "counter", "Stale connections removed from the pool.", func(s *redis.PoolStats) uint32 { return s.StaleConns }},
+			{"cache_redis_pool_connections", "gauge", "Connections in the pool.", func(s *redis.PoolStats) uint32 { return s.TotalConns }},
+		} {
+			fmt.Fprintf(cw, "# HELP %s %s\n", metric.name, metric.help)
+			fmt.Fprintf(cw, "# TYPE %s %s\n", metric.name, metric.kind)
+			for i, name := range names {
+				fmt.Fprintf(cw, "%s{cache=%s} %d\n", metric.name, quoteLabel(name), metric.value(pools[i]))
+			}
+		}
+	}
+
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format, so that m can
+// be mounted as the /metrics endpoint.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// InstrumentedCache is a Cache recording the metrics of every call to the
+// Metrics it was returned by.
+type InstrumentedCache struct {
+	cache   Cache
+	name    string
+	metrics *Metrics
+}
+
+// outcome classifies the result of an operation.
+func outcome(err error, read bool) string {
+	switch err {
+	case nil:
+		if read {
+			return outcomeHit
+		}
+		return outcomeOK
+	case ErrCacheMiss:
+		return outcomeMiss
+	case ErrNegativeHit:
+		return outcomeNegativeHit
+	case ErrNotStored:
+		return outcomeNotStored
+	}
+	return outcomeError
+}
+
+func (c *InstrumentedCache) observe(op string, read bool, start time.Time, err error) {
+	c.metrics.observe(c.name, op, outcome(err, read), time.Since(start))
+}
+
+func (c *InstrumentedCache) Get(key string, ptrValue interface{}) error {
+	start := time.Now()
+	err := c.cache.Get(key, ptrValue)
+	c.observe("get", true, start, err)
+	return err
+}
+
+func (c *InstrumentedCache) GetMulti(keys ...string) (Getter, error) {
+	start := time.Now()
+	g, err := c.cache.GetMulti(keys...)
+	c.observe("get_multi", true, start, err)
+	return g, err
+}
+
+func (c *InstrumentedCache) Set(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := c.cache.Set(key, value, expires)
+	c.observe("set", false, start, err)
+	return err
+}
+
+func (c *InstrumentedCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := c.cache.SetFields(key, value, expires)
+	c.observe("set_fields", false, start, err)
+	return err
+}
+
+func (c *InstrumentedCache) Add(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := c.cache.Add(key, value, expires)
+	c.observe("add", false, start, err)
+	return err
+}
+
+func (c *InstrumentedCache) Replace(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := c.cache.Replace(key, value, expires)
+	c.observe("replace", false, start, err)
+	return err
+}
+
+func (c *InstrumentedCache) Delete(key string) error {
+	start := time.Now()
+	err := c.cache.Delete(key)
+	c.observe("delete", false, start, err)
+	return err
+}
+
+func (c *InstrumentedCache) Flush() error {
+	start := time.Now()
+	err := c.cache.Flush()
+	c.observe("flush", false, start, err)
+	return err
+}
+
+func (c *InstrumentedCache) Keys() ([]string, error) {
+	start := time.Now()
+	keys, err := c.cache.Keys()
+	c.observe("keys", false, start, err)
+	return keys, err
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var newInstrumentedCache = func(t *testing.T, defaultExpiration time.Duration) Cache {
+	return NewMetrics().Instrument("test", newInMemoryCache(t, defaultExpiration))
+}
+
+func TestInstrumentedCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newInstrumentedCache)
+}
+
+func TestInstrumentedCache_Add(t *testing.T) {
+	testAdd(t, newInstrumentedCache)
+}
+
+func TestInstrumentedCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newInstrumentedCache)
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics(0.5, 1)
+	c := m.Instrument("users", NewInMemoryCache(time.Hour))
+
+	var value string
+	c.Set("key", "foo", time.Hour)
+	c.Get("key", &value)
+	c.Get("key", &value)
+	c.Get("missing", &value)
+	c.Add("key", "bar", time.Hour)
+	c.Get("key", 42)
+
+	var b bytes.Buffer
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatalf("Error writing metrics: %s", err)
+	}
+
+	for _, line := range []string{
+		"# TYPE cache_operations_total counter",
+		`cache_operations_total{cache="users",operation="get",outcome="hit"} 2`,
+		`cache_operations_total{cache="users",operation="get",outcome="miss"} 1`,
+		`cache_operations_total{cache="users",operation="get",outcome="error"} 1`,
+		`cache_operations_total{cache="users",operation="set",outcome="ok"} 1`,
+		`cache_operations_total{cache="users",operation="add",outcome="not_stored"} 1`,
+		"# TYPE cache_operation_duration_seconds histogram",
+		`cache_operation_duration_seconds_bucket{cache="users",operation="get",le="0.5"} 4`,
+		`cache_operation_duration_seconds_bucket{cache="users",operation="get",le="1"} 4`,
+		`cache_operation_duration_seconds_bucket{cache="users",operation="get",le="+Inf"} 4`,
+		`cache_operation_duration_seconds_count{cache="users",operation="get"} 4`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, b.String())
+		}
+	}
+
+	if strings.Contains(b.String(), "cache_redis_pool") {
+		t.Errorf("Expected no pool stats without a RedisCache")
+	}
+}
+
+func TestMetrics_PoolStats(t *testing.T) {
+	m := NewMetrics()
+	m.Instrument(`re"dis`, NewRedisCache(RedisOpts{Host: redisTestServer}))
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, line := range []string{
+		"# TYPE cache_redis_pool_hits_total counter",
+		`cache_redis_pool_hits_total{cache="re\"dis"} 0`,
+		"# TYPE cache_redis_pool_connections gauge",
+		`cache_redis_pool_connections{cache="re\"dis"} 0`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, w.Body.String())
+		}
+	}
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+}
+
+// stalledWriter blocks every write until released, as a stalled scrape does.
+type stalledWriter struct {
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.release
+	return len(p), nil
+}
+
+func TestMetrics_StalledScrape(t *testing.T) {
+	m := NewMetrics()
+	c := m.Instrument("users", NewInMemoryCache(time.Hour))
+
+	// Enough metrics to fill the buffer of WriteTo before it is done.
+	for i := 0; i < 100; i++ {
+		m.Instrument(fmt.Sprintf("cache-%d", i), NewInMemoryCache(time.Hour)).Get("key", new(string))
+	}
+
+	w := &stalledWriter{writing: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(w.release)
+	go m.WriteTo(w)
+	<-w.writing
+
+	done := make(chan struct{})
+	go func() {
+		c.Get("key", new(string))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Expected calls not to wait for the scrape")
+	}
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+// memoryStore counts requests in an InMemoryCache, updating the counts under
+// its lock.
+type memoryStore struct {
+	cache cache.InMemoryCache
+}
+
+// memoryBucket is how a token bucket is stored.
+type memoryBucket struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+func (s memoryStore) fixed(key string, limit int, ttl time.Duration, now time.Time) (bool, int, error) {
+	var count int
+	var allowed bool
+	err := s.cache.Update(key, &count, ttl, func(bool) (bool, error) {
+		allowed = count < limit
+		if allowed {
+			count++
+		}
+		return allowed, nil
+	})
+	return allowed, count, err
+}
+
+func (s memoryStore) sliding(curr, prev string, limit int, weight float64, ttl time.Duration, now time.Time) (bool, int, int, error) {
+	var prevCount int
+	if err := s.cache.Get(prev, &prevCount); err != nil && err != cache.ErrCacheMiss && err != cache.ErrNegativeHit {
+		return false, 0, 0, err
+	}
+
+	var count int
+	var allowed bool
+	err := s.cache.Update(curr, &count, ttl, func(bool) (bool, error) {
+		allowed = float64(prevCount)*weight+float64(count) < float64(limit)
+		if allowed {
+			count++
+		}
+		return allowed, nil
+	})
+	return allowed, prevCount, count, err
+}
+
+func (s memoryStore) bucket(key string, capacity, rate float64, ttl time.Duration, now time.Time) (bool, float64, error) {
+	var b memoryBucket
+	var allowed bool
+	err := s.cache.Update(key, &b, ttl, func(found bool) (bool, error) {
+		if !found {
+			b.Tokens, b.Updated = capacity, now
+		}
+
+		if now.After(b.Updated) {
+			b.Tokens = math.Min(capacity, b.Tokens+now.Sub(b.Updated).Seconds()*rate)
+			b.Updated = now
+		}
+
+		allowed = b.Tokens >= 1
+		if allowed {
+			b.Tokens--
+		}
+		return true, nil
+	})
+	return allowed, b.Tokens, err
+}
@@ -0,0 +1,234 @@
+// Package ratelimit limits how often keys, such as users or IP addresses, may
+// do something, keeping count in the backends of the cache package.
+//
+// On a RedisCache, every decision is a single Lua script, so limits hold
+// across processes. On an InMemoryCache, counts are updated under the lock of
+// the cache, so limiters given the same cache share them within the process.
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+// ErrUnsupportedBackend is returned for the caches limiters cannot count in.
+var ErrUnsupportedBackend = errors.New("ratelimit: unsupported backend")
+
+// ErrInvalidLimit is returned when Limit or Window are not positive.
+var ErrInvalidLimit = errors.New("ratelimit: limit and window must be positive")
+
+const defaultPrefix = "ratelimit:"
+
+// Clock tells the time, so that tests can control it.
+type Clock = cache.Clock
+
+// Opts configures a Limiter.
+type Opts struct {
+	// Limit is how many requests a key may make per Window.
+	Limit  int
+	Window time.Duration
+	// Prefix of the keys counted in the backend. Defaults to "ratelimit:".
+	Prefix string
+	// Clock defaults to the system clock. On Redis, the time of the caller is
+	// the one used, both to pick the window and to expire its counts when it
+	// ends, so the clocks of the callers sharing limits should agree.
+	Clock Clock
+	// Logger receives the errors of the backend.
+	Logger cache.Logger
+	// FailClosed denies requests when the backend fails, instead of allowing
+	// them.
+	FailClosed bool
+}
+
+func (o Opts) padDefaults() (Opts, error) {
+	if o.Limit <= 0 || o.Window <= 0 {
+		return o, ErrInvalidLimit
+	}
+
+	if o.Prefix == "" {
+		o.Prefix = defaultPrefix
+	}
+
+	if o.Clock == nil {
+		o.Clock = cache.SystemClock{}
+	}
+	return o, nil
+}
+
+// Limiter decides whether a key may make one more request.
+type Limiter interface {
+	// Allow counts a request of key, if allowed. It returns how many more
+	// requests key may make right now, and when it has its whole limit back.
+	Allow(key string) (allowed bool, remaining int, resetAt time.Time)
+}
+
+// store counts requests in a backend.
+type store interface {
+	// fixed counts a request in key, unless limit were already counted. The
+	// count expires after ttl.
+	fixed(key string, limit int, ttl time.Duration, now time.Time) (allowed bool, count int, err error)
+	// sliding counts a request in curr, unless the count of prev weighted by
+	// weight and the count of curr reach limit.
+	sliding(curr, prev string, limit int, weight float64, ttl time.Duration, now time.Time) (allowed bool, prevCount, currCount int, err error)
+	// bucket takes a token from key, a bucket of capacity tokens refilled at
+	// rate tokens per second, and returns how many are left.
+	bucket(key string, capacity, rate float64, ttl time.Duration, now time.Time) (allowed bool, tokens float64, err error)
+}
+
+// newStore returns the store counting in the backend of c.
+func newStore(c cache.Cache) (store, error) {
+	switch c := c.(type) {
+	case *cache.RedisCache:
+		return redisStore{client: c.Client()}, nil
+	case cache.InMemoryCache:
+		return memoryStore{cache: c}, nil
+	case *cache.InMemoryCache:
+		return memoryStore{cache: *c}, nil
+	}
+	return nil, ErrUnsupportedBackend
+}
+
+// limiter holds what the algorithms share.
+type limiter struct {
+	store store
+	opts  Opts
+}
+
+func newLimiter(c cache.Cache, opts Opts) (limiter, error) {
+	opts, err := opts.padDefaults()
+	if err != nil {
+		return limiter{}, err
+	}
+
+	s, err := newStore(c)
+	if err != nil {
+		return limiter{}, err
+	}
+	return limiter{store: s, opts: opts}, nil
+}
+
+// failed decides for a request whose count failed.
+func (l limiter) failed(key string, now time.Time, err error) (bool, int, time.Time) {
+	if l.opts.Logger != nil {
+		l.opts.Logger.Error("ratelimit: cannot count request", "key", key, "error", err)
+	}
+	return !l.opts.FailClosed, 0, now
+}
+
+// window returns the start of the window now falls in, and its number.
+func (l limiter) window(now time.Time) (time.Time, int64) {
+	n := now.UnixNano() / int64(l.opts.Window)
+	return time.Unix(0, n*int64(l.opts.Window)), n
+}
+
+func (l limiter) key(key string, window int64) string {
+	return l.opts.Prefix + key + ":" + strconv.FormatInt(window, 10)
+}
+
+// FixedWindow allows Limit requests in every Window, the windows starting at
+// multiples of it. Keys may make up to twice the limit across the boundary of
+// two windows.
+type FixedWindow struct {
+	limiter
+}
+
+// NewFixedWindow returns a FixedWindow counting in c.
+func NewFixedWindow(c cache.Cache, opts Opts) (*FixedWindow, error) {
+	l, err := newLimiter(c, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &FixedWindow{l}, nil
+}
+
+func (l *FixedWindow) Allow(key string) (bool, int, time.Time) {
+	now := l.opts.Clock.Now()
+	start, n := l.window(now)
+	resetAt := start.Add(l.opts.Window)
+
+	allowed, count, err := l.store.fixed(l.key(key, n), l.opts.Limit, resetAt.Sub(now), now)
+	if err != nil {
+		return l.failed(key, now, err)
+	}
+	remaining := l.opts.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return allowed, remaining, resetAt
+}
+
+// SlidingWindow allows Limit requests in any Window, estimating the requests
+// made in the sliding window from the counts of the current fixed window and
+// of the previous one, as if the requests of the previous one had been evenly
+// spread.
+type SlidingWindow struct {
+	limiter
+}
+
+// NewSlidingWindow returns a SlidingWindow counting in c.
+func NewSlidingWindow(c cache.Cache, opts Opts) (*SlidingWindow, error) {
+	l, err := newLimiter(c, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &SlidingWindow{l}, nil
+}
+
+func (l *SlidingWindow) Allow(key string) (bool, int, time.Time) {
+	now := l.opts.Clock.Now()
+	start, n := l.window(now)
+	weight := 1 - float64(now.Sub(start))/float64(l.opts.Window)
+
+	// The count of the current window is needed until the next one ends.
+	ttl := start.Add(2 * l.opts.Window).Sub(now)
+	allowed, prev, curr, err := l.store.sliding(l.key(key, n), l.key(key, n-1), l.opts.Limit, weight, ttl, now)
+	if err != nil {
+		return l.failed(key, now, err)
+	}
+
+	// The requests of the current window slide out during the next one.
+	resetAt := start.Add(l.opts.Window)
+	if curr > 0 {
+		resetAt = resetAt.Add(l.opts.Window)
+	}
+
+	used := float64(prev)*weight + float64(curr)
+	remaining := int(math.Floor(float64(l.opts.Limit) - used))
+	if remaining < 0 {
+		remaining = 0
+	}
+	return allowed, remaining, resetAt
+}
+
+// TokenBucket allows bursts of up to Limit requests, refilling the allowance
+// of a key at Limit requests per Window.
+type TokenBucket struct {
+	limiter
+}
+
+// NewTokenBucket returns a TokenBucket counting in c.
+func NewTokenBucket(c cache.Cache, opts Opts) (*TokenBucket, error) {
+	l, err := newLimiter(c, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenBucket{l}, nil
+}
+
+func (l *TokenBucket) Allow(key string) (bool, int, time.Time) {
+	now := l.opts.Clock.Now()
+	capacity := float64(l.opts.Limit)
+	rate := capacity / l.opts.Window.Seconds()
+
+	allowed, tokens, err := l.store.bucket(l.opts.Prefix+key, capacity, rate, l.opts.Window, now)
+	if err != nil {
+		return l.failed(key, now, err)
+	}
+
+	refill := time.Duration((capacity - tokens) / rate * float64(time.Second))
+	return allowed, int(math.Floor(tokens)), now.Add(refill)
+}
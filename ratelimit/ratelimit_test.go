@@ -0,0 +1,240 @@
+package ratelimit
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// These tests require redis server running on localhost:6379 (the default)
+func backends(t *testing.T) map[string]cache.Cache {
+	redis := cache.NewRedisCache(cache.RedisOpts{Host: "localhost:6379"})
+	if err := redis.Flush(); err != nil {
+		t.Fatalf("couldn't connect to redis: %s", err)
+	}
+
+	return map[string]cache.Cache{
+		"memory": cache.NewInMemoryCache(time.Hour),
+		"redis":  redis,
+	}
+}
+
+// expect checks the outcome of the next request of key.
+func expect(t *testing.T, l Limiter, allowed bool, remaining int) time.Time {
+	t.Helper()
+	gotAllowed, gotRemaining, resetAt := l.Allow("user")
+	if gotAllowed != allowed || gotRemaining != remaining {
+		t.Fatalf("Expected allowed %v with %d remaining, got %v with %d", allowed, remaining, gotAllowed, gotRemaining)
+	}
+	return resetAt
+}
+
+func TestFixedWindow(t *testing.T) {
+	for name, c := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			start := clock.Now()
+			l, err := NewFixedWindow(c, Opts{Limit: 3, Window: time.Minute, Clock: clock})
+			if err != nil {
+				t.Fatalf("Error creating the limiter: %s", err)
+			}
+
+			clock.Advance(10 * time.Second)
+			expect(t, l, true, 2)
+			expect(t, l, true, 1)
+			expect(t, l, true, 0)
+			if resetAt := expect(t, l, false, 0); !resetAt.Equal(start.Add(time.Minute)) {
+				t.Errorf("Expected the limit back at the end of the window, got %s", resetAt)
+			}
+
+			if allowed, _, _ := l.Allow("other"); !allowed {
+				t.Errorf("Expected keys to be limited separately")
+			}
+
+			clock.Advance(time.Minute)
+			expect(t, l, true, 2)
+		})
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	for name, c := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			l, err := NewSlidingWindow(c, Opts{Limit: 10, Window: time.Minute, Clock: clock})
+			if err != nil {
+				t.Fatalf("Error creating the limiter: %s", err)
+			}
+
+			for i := 9; i >= 0; i-- {
+				expect(t, l, true, i)
+			}
+			expect(t, l, false, 0)
+
+			// Half of the previous window still counts.
+			clock.Advance(90 * time.Second)
+			for i := 4; i >= 0; i-- {
+				expect(t, l, true, i)
+			}
+			expect(t, l, false, 0)
+
+			clock.Advance(2 * time.Minute)
+			expect(t, l, true, 9)
+		})
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	for name, c := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			l, err := NewTokenBucket(c, Opts{Limit: 4, Window: time.Minute, Clock: clock})
+			if err != nil {
+				t.Fatalf("Error creating the limiter: %s", err)
+			}
+
+			expect(t, l, true, 3)
+			expect(t, l, true, 2)
+			expect(t, l, true, 1)
+			if resetAt := expect(t, l, true, 0); !resetAt.Equal(clock.Now().Add(time.Minute)) {
+				t.Errorf("Expected the bucket full again in a minute, got %s", resetAt)
+			}
+			expect(t, l, false, 0)
+
+			// A token is added every 15 seconds.
+			clock.Advance(15 * time.Second)
+			expect(t, l, true, 0)
+			expect(t, l, false, 0)
+
+			clock.Advance(time.Hour)
+			expect(t, l, true, 3)
+		})
+	}
+}
+
+func TestSharedCounts(t *testing.T) {
+	for name, c := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			var limiters []Limiter
+			for i := 0; i < 2; i++ {
+				l, err := NewFixedWindow(c, Opts{Limit: 2, Window: time.Minute, Clock: clock})
+				if err != nil {
+					t.Fatalf("Error creating the limiter: %s", err)
+				}
+				limiters = append(limiters, l)
+			}
+
+			// Limiters given the same cache count in it together.
+			expect(t, limiters[0], true, 1)
+			expect(t, limiters[1], true, 0)
+			expect(t, limiters[0], false, 0)
+		})
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	if _, err := NewFixedWindow(cache.NewInMemoryCache(time.Hour), Opts{Window: time.Minute}); err != ErrInvalidLimit {
+		t.Errorf("Expected an invalid limit, got %v", err)
+	}
+
+	tiered := cache.NewTieredCache(cache.NewInMemoryCache(time.Hour), cache.NewInMemoryCache(time.Hour), time.Minute)
+	if _, err := NewTokenBucket(tiered, Opts{Limit: 1, Window: time.Minute}); err != ErrUnsupportedBackend {
+		t.Errorf("Expected an unsupported backend, got %v", err)
+	}
+}
+
+func TestFailClosed(t *testing.T) {
+	down := cache.NewRedisCache(cache.RedisOpts{Host: "localhost:1", Retry: cache.RetryPolicy{MaxAttempts: 1}})
+	for _, failClosed := range []bool{false, true} {
+		l, err := NewFixedWindow(down, Opts{Limit: 1, Window: time.Minute, FailClosed: failClosed})
+		if err != nil {
+			t.Fatalf("Error creating the limiter: %s", err)
+		}
+
+		if allowed, _, _ := l.Allow("user"); allowed == failClosed {
+			t.Errorf("Expected allowed %v when the backend is down", !failClosed)
+		}
+	}
+}
+
+func TestRedisExpiry(t *testing.T) {
+	c := backends(t)["redis"].(*cache.RedisCache)
+	client := c.Client()
+	clock := newFakeClock()
+	window := clock.Now().UnixNano() / int64(time.Minute)
+	key := func(n int64) string {
+		return "ratelimit:user:" + strconv.FormatInt(n, 10)
+	}
+
+	// pttl checks that key expires in about d.
+	pttl := func(key string, d time.Duration) {
+		t.Helper()
+		ttl, err := client.PTTL(key).Result()
+		if err != nil {
+			t.Fatalf("Error getting the ttl of %s: %s", key, err)
+		}
+
+		if ttl > d || ttl < d-time.Second {
+			t.Errorf("Expected %s to expire in %s, got %s", key, d, ttl)
+		}
+	}
+
+	fixed, err := NewFixedWindow(c, Opts{Limit: 2, Window: time.Minute, Clock: clock})
+	if err != nil {
+		t.Fatalf("Error creating the limiter: %s", err)
+	}
+
+	// Counts expire when their window ends, by the clock of the caller,
+	// rather than a window after the first request.
+	clock.Advance(10 * time.Second)
+	expect(t, fixed, true, 1)
+	pttl(key(window), 50*time.Second)
+
+	// Even a count left without an expiry gets one, once denying.
+	clock.Advance(10 * time.Second)
+	expect(t, fixed, true, 0)
+	client.Persist(key(window))
+	expect(t, fixed, false, 0)
+	pttl(key(window), 40*time.Second)
+
+	// The next window starts from nothing.
+	clock.Advance(40 * time.Second)
+	expect(t, fixed, true, 1)
+	pttl(key(window+1), time.Minute)
+
+	client.Del(key(window), key(window+1))
+	sliding, err := NewSlidingWindow(c, Opts{Limit: 2, Window: time.Minute, Clock: clock})
+	if err != nil {
+		t.Fatalf("Error creating the limiter: %s", err)
+	}
+
+	// The count of a sliding window is needed until the next one ends.
+	clock.Advance(15 * time.Second)
+	expect(t, sliding, true, 1)
+	pttl(key(window+1), 105*time.Second)
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	// The expiry of the counts is set on every call rather than on the first
+	// increment only, so that a count never outlives its window, even if it
+	// was left without one.
+	redisFixedScript = redis.NewScript(`
+local count = tonumber(redis.call("get", KEYS[1]) or "0")
+local allowed = 0
+if count < tonumber(ARGV[1]) then
+	count = redis.call("incr", KEYS[1])
+	allowed = 1
+end
+
+redis.call("pexpire", KEYS[1], ARGV[2])
+return {allowed, count}
+`)
+
+	redisSlidingScript = redis.NewScript(`
+local curr = tonumber(redis.call("get", KEYS[1]) or "0")
+local prev = tonumber(redis.call("get", KEYS[2]) or "0")
+local allowed = 0
+if prev * tonumber(ARGV[2]) + curr < tonumber(ARGV[1]) then
+	curr = redis.call("incr", KEYS[1])
+	allowed = 1
+end
+
+redis.call("pexpire", KEYS[1], ARGV[3])
+return {allowed, prev, curr}
+`)
+
+	// Lua numbers are returned as integers, so the tokens left are returned
+	// as a string.
+	redisBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[4])
+local bucket = redis.call("hmget", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1]) or capacity
+local updated = tonumber(bucket[2]) or now
+
+if now > updated then
+	tokens = math.min(capacity, tokens + (now - updated) / 1e6 * rate)
+	updated = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("hmset", KEYS[1], "tokens", tostring(tokens), "updated", tostring(updated))
+redis.call("pexpire", KEYS[1], ARGV[3])
+return {allowed, tostring(tokens)}
+`)
+)
+
+// redisStore counts requests in Redis, each decision being a single script.
+type redisStore struct {
+	client *redis.Client
+}
+
+// ms returns d in milliseconds, at least one so that PEXPIRE does not delete
+// what it is given.
+func ms(d time.Duration) int64 {
+	if d < time.Millisecond {
+		return 1
+	}
+	return int64(d / time.Millisecond)
+}
+
+func (s redisStore) fixed(key string, limit int, ttl time.Duration, now time.Time) (bool, int, error) {
+	res, err := redisFixedScript.Run(s.client, []string{key}, limit, ms(ttl)).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	vals := res.([]interface{})
+	return vals[0].(int64) == 1, int(vals[1].(int64)), nil
+}
+
+func (s redisStore) sliding(curr, prev string, limit int, weight float64, ttl time.Duration, now time.Time) (bool, int, int, error) {
+	res, err := redisSlidingScript.Run(s.client, []string{curr, prev}, limit, strconv.FormatFloat(weight, 'f', -1, 64), ms(ttl)).Result()
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	vals := res.([]interface{})
+	return vals[0].(int64) == 1, int(vals[1].(int64)), int(vals[2].(int64)), nil
+}
+
+func (s redisStore) bucket(key string, capacity, rate float64, ttl time.Duration, now time.Time) (bool, float64, error) {
+	// Times are passed in microseconds, which Lua numbers hold exactly.
+	res, err := redisBucketScript.Run(s.client, []string{key},
+		strconv.FormatFloat(capacity, 'f', -1, 64),
+		strconv.FormatFloat(rate, 'f', -1, 64),
+		ms(ttl),
+		now.UnixNano()/int64(time.Microsecond),
+	).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	vals := res.([]interface{})
+	tokens, err := strconv.ParseFloat(vals[1].(string), 64)
+	if err != nil {
+		return false, 0, err
+	}
+	return vals[0].(int64) == 1, tokens, nil
+}
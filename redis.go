@@ -5,23 +5,37 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
 	"time"
 
 	"encoding/json"
-	"errors"
-	"fmt"
 
 	"github.com/go-redis/redis"
-	"github.com/meson10/highbrow"
 )
 
 // RedisCache wraps the Redis client to meet the Cache interface.
 type RedisCache struct {
-	pool              *redis.Client
-	defaultExpiration time.Duration
-	lockRetries       int
+	pool                 *redis.Client
+	defaultExpiration    time.Duration
+	negativeExpiration   time.Duration
+	compression          Compression
+	compressionThreshold int
+	lockRetries          int
+	lockBackoff          Backoff
+	replicas             *redisReplicas
+	writes               *recentWrites
+	retry                RetryPolicy
+	logger               Logger
+	events               redisEvents
 }
 
+// redisTombstone is stored in place of the value of keys known to be
+// missing. It is not valid JSON, so it cannot clash with a stored value.
+var redisTombstone = []byte("\x00cache:negative")
+
 const (
 	defaultMaxIdle        = 5
 	defaultMaxActive      = 0
@@ -35,16 +49,36 @@ const (
 )
 
 type RedisOpts struct {
-	MaxIdle        int
-	MaxActive      int
-	Protocol       string
-	Host           string
-	Password       string
-	Expiration     time.Duration
-	TimeoutConnect int
-	TimeoutRead    int
-	TimeoutWrite   int
-	TimeoutIdle    int
+	MaxIdle              int
+	MaxActive            int
+	Protocol             string
+	Host                 string
+	Password             string
+	Expiration           time.Duration
+	NegativeExpiration   time.Duration
+	Compression          Compression
+	CompressionThreshold int
+	TimeoutConnect       int
+	TimeoutRead          int
+	TimeoutWrite         int
+	TimeoutIdle          int
+	LockRetries          int
+	LockBackoff          Backoff
+	// Replicas are the addresses of read replicas of Host. Get, GetMulti and
+	// Keys are served by them, everything else by Host.
+	Replicas         []string
+	ReplicaSelection ReplicaSelection
+	// ReadYourWrites sends reads of a key to Host for this long after it was
+	// written through this RedisCache, so they are not served by a replica
+	// which has yet to catch up.
+	ReadYourWrites time.Duration
+	// Retry is the policy for retrying commands which failed on a transient
+	// error.
+	Retry RetryPolicy
+	// Logger receives the errors which are recovered from, such as reads
+	// failing on a replica, connections failing and being made again, and
+	// the undecodable messages skipped by a RedisBus.
+	Logger Logger
 }
 
 func (r RedisOpts) padDefaults() RedisOpts {
@@ -80,6 +114,25 @@ func (r RedisOpts) padDefaults() RedisOpts {
 		r.Protocol = defaultProtocol
 	}
 
+	if r.NegativeExpiration == 0 {
+		r.NegativeExpiration = defaultNegativeExpiration
+	}
+
+	if r.CompressionThreshold == 0 {
+		r.CompressionThreshold = defaultCompressionThreshold
+	}
+
+	if r.LockRetries == 0 {
+		r.LockRetries = defaultLockRetries
+	}
+
+	if r.LockBackoff == nil {
+		r.LockBackoff = defaultLockBackoff
+	}
+
+	r.Logger = loggerOrNop(r.Logger)
+	r.Retry.logger = r.Logger
+	r.Retry = r.Retry.padDefaults()
 	return r
 }
 
@@ -103,59 +156,247 @@ func NewRedisCache(opts RedisOpts) *RedisCache {
 		Password:           opts.Password,
 		IdleCheckFrequency: 500 * time.Millisecond,
 	}
+	opt.Dialer = newRedisDialer(opts.Protocol, opts.Host, toc, opts.Logger).dial
+
+	c := &RedisCache{
+		pool:                 redis.NewClient(opt),
+		negativeExpiration:   opts.NegativeExpiration,
+		compression:          opts.Compression,
+		compressionThreshold: opts.CompressionThreshold,
+		lockRetries:          opts.LockRetries,
+		lockBackoff:          opts.LockBackoff,
+		retry:                opts.Retry,
+		logger:               opts.Logger,
+	}
+
+	if len(opts.Replicas) > 0 {
+		var clients []*redis.Client
+		for _, addr := range opts.Replicas {
+			replicaOpt := *opt
+			replicaOpt.Addr = addr
+			replicaOpt.Dialer = newRedisDialer(opts.Protocol, addr, toc, opts.Logger).dial
+			clients = append(clients, redis.NewClient(&replicaOpt))
+		}
+		c.replicas = newRedisReplicas(clients, opts.ReplicaSelection, tor)
+	}
 
-	c := redis.NewClient(opt)
-	return &RedisCache{pool: c, lockRetries: lockRetries}
+	if opts.ReadYourWrites > 0 {
+		c.writes = newRecentWrites(opts.ReadYourWrites)
+	}
+	return c
 }
 
-func (c *RedisCache) Set(key string, value interface{}, expires time.Duration) error {
+// redisDialer connects to a Redis server, logging when it cannot and when it
+// can again, once per outage.
+type redisDialer struct {
+	network string
+	addr    string
+	timeout time.Duration
+	logger  Logger
+	down    int32 // Whether the last dial failed.
+}
+
+func newRedisDialer(network, addr string, timeout time.Duration, logger Logger) *redisDialer {
+	return &redisDialer{network: network, addr: addr, timeout: timeout, logger: logger}
+}
+
+func (d *redisDialer) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout(d.network, d.addr, d.timeout)
+	if err != nil {
+		if atomic.CompareAndSwapInt32(&d.down, 0, 1) {
+			d.logger.Error("cache: cannot connect to redis", "addr", d.addr, "error", err)
+		}
+		return nil, err
+	}
+
+	if atomic.CompareAndSwapInt32(&d.down, 1, 0) {
+		d.logger.Warn("cache: reconnected to redis", "addr", d.addr)
+	}
+	return conn, nil
+}
+
+// read runs op against a replica, or against the primary if there are none or
+// some of keys were written too recently. A read failing on a replica is
+// retried on the primary, and then as the retry policy allows.
+func (c *RedisCache) read(op func(client *redis.Client) error, keys ...string) error {
+	return c.retry.do(true, func() error {
+		return c.readOnce(op, c.writes != nil && c.writes.recent(keys...))
+	})
+}
+
+// readAll is read for ops which may see any key, such as KEYS, so that they
+// go to the primary if any key was written too recently.
+func (c *RedisCache) readAll(op func(client *redis.Client) error) error {
+	return c.retry.do(true, func() error {
+		return c.readOnce(op, c.writes != nil && c.writes.recentAny())
+	})
+}
+
+func (c *RedisCache) readOnce(op func(client *redis.Client) error, primary bool) error {
+	if c.replicas == nil || primary {
+		return op(c.pool)
+	}
+
+	i := c.replicas.pick()
+	start := time.Now()
+	err := op(c.replicas.clients[i])
+	c.replicas.observe(i, time.Since(start), err)
+	if err != nil && err != redis.Nil {
+		c.logger.Warn("cache: replica read failed, reading from the primary", "replica", c.replicas.clients[i].Options().Addr, "error", err)
+		return op(c.pool)
+	}
+	return err
+}
+
+// written records a write of key for ReadYourWrites.
+func (c *RedisCache) written(key string) {
+	if c.writes != nil {
+		c.writes.add(key)
+	}
+}
+
+// encode turns value into what is stored in Redis.
+func (c *RedisCache) encode(value interface{}) ([]byte, error) {
 	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return compress(c.compression, c.compressionThreshold, b)
+}
+
+// decodeRedisValue reverses encode.
+func decodeRedisValue(b []byte, ptrValue interface{}) error {
+	if bytes.Equal(b, redisTombstone) {
+		return ErrNegativeHit
+	}
+
+	b, err := decompress(b)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, ptrValue)
+}
+
+func (c *RedisCache) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := c.encode(value)
 	if err != nil {
 		return err
 	}
-	return c.pool.Set(key, b, expires).Err()
+
+	defer c.written(key)
+	return c.retry.do(true, func() error {
+		return c.pool.Set(key, b, expires).Err()
+	})
 }
 
-const lockRetries = 5
+var (
+	redisUnlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+	redisRefreshScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+// Lock implements Locker on top of SET NX, releasing and refreshing the lock
+// through Lua scripts that check the token first.
+func (c *RedisCache) Lock(ctx context.Context, key string, lease time.Duration) (Lock, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &redisLock{pool: c.pool, key: key, token: token}
+	err = obtainLock(ctx, c.lockRetries, c.lockBackoff, func() (ok bool, err error) {
+		// If the reply is lost, the lock may be ours already.
+		err = c.retry.do(false, func() error {
+			ok, err = c.pool.WithContext(ctx).SetNX(lockKey(key), token, lease).Result()
+			return err
+		})
+		return ok, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
 
 func (c *RedisCache) lockRetry(key string, op func() error) error {
-	var breakErr error
+	l, err := c.Lock(context.Background(), key, defaultLockLease)
+	if err != nil {
+		return err
+	}
 
-	err := highbrow.Try(c.lockRetries, func() error {
-		lockKey := fmt.Sprintf("%v-op", key)
-		ret, err := c.pool.SetNX(lockKey, "1", 5*time.Second).Result()
-		if err != nil {
-			breakErr = err
-			return nil
-		}
+	defer l.Unlock(context.Background())
+	return op()
+}
 
-		if !ret {
-			return errors.New("Cannot get Lock. Retrying.")
-		}
+type redisLock struct {
+	pool  *redis.Client
+	key   string
+	token string
+}
 
-		defer func() {
-			c.pool.Del(lockKey)
-		}()
+func (l *redisLock) Key() string {
+	return l.key
+}
 
-		breakErr = op()
-		return nil
-	})
+func (l *redisLock) Token() string {
+	return l.token
+}
+
+func (l *redisLock) Refresh(ctx context.Context, lease time.Duration) error {
+	ms := int64(lease / time.Millisecond)
+	return lockScriptResult(redisRefreshScript.Run(l.pool.WithContext(ctx), []string{lockKey(l.key)}, l.token, ms))
+}
+
+func (l *redisLock) Unlock(ctx context.Context) error {
+	return lockScriptResult(redisUnlockScript.Run(l.pool.WithContext(ctx), []string{lockKey(l.key)}, l.token))
+}
 
-	if breakErr == nil {
+// lockScriptResult turns the result of a lock script, zero when the token did
+// not match, into an error. The version of go-redis we pin has no Cmd.Int64,
+// so the result is asserted.
+func lockScriptResult(cmd *redis.Cmd) error {
+	res, err := cmd.Result()
+	if err != nil {
 		return err
 	}
-	return breakErr
+
+	if n, _ := res.(int64); n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
 }
 
 func (c *RedisCache) Add(key string, value interface{}, expires time.Duration) error {
+	b, err := c.encode(value)
+	if err != nil {
+		return err
+	}
+
+	defer c.written(key)
 	return c.lockRetry(key, func() error {
-		exists, err := c.pool.Exists(key).Result()
+		var exists int64
+		err := c.retry.do(true, func() (err error) {
+			exists, err = c.pool.Exists(key).Result()
+			return err
+		})
 		if err != nil {
 			return err
 		}
 
 		if exists == 0 {
-			return c.pool.Set(key, value, expires).Err()
+			return c.retry.do(true, func() error {
+				return c.pool.Set(key, b, expires).Err()
+			})
 		}
 
 		return ErrNotStored
@@ -163,9 +404,17 @@ func (c *RedisCache) Add(key string, value interface{}, expires time.Duration) e
 }
 
 func (c *RedisCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	defer c.written(key)
 	return c.lockRetry(key, func() error {
 		var ptrValue map[string]interface{}
-		if err := c.Get(key, &ptrValue); err != nil {
+		err := c.retry.do(true, func() error {
+			return c.get(c.pool, key, &ptrValue)
+		})
+		if err == ErrCacheMiss || err == ErrNegativeHit {
+			return ErrNotStored
+		}
+
+		if err != nil {
 			return err
 		}
 
@@ -173,13 +422,23 @@ func (c *RedisCache) SetFields(key string, value map[string]interface{}, expires
 			ptrValue[k] = v
 		}
 
-		return c.Set(key, value, expires)
+		return c.Set(key, ptrValue, expires)
 	})
 }
 
 func (c *RedisCache) Replace(key string, value interface{}, expires time.Duration) error {
+	b, err := c.encode(value)
+	if err != nil {
+		return err
+	}
+
+	defer c.written(key)
 	return c.lockRetry(key, func() error {
-		exists, err := c.pool.Exists(key).Result()
+		var exists int64
+		err := c.retry.do(true, func() (err error) {
+			exists, err = c.pool.Exists(key).Result()
+			return err
+		})
 		if err != nil {
 			return err
 		}
@@ -188,13 +447,21 @@ func (c *RedisCache) Replace(key string, value interface{}, expires time.Duratio
 			return ErrNotStored
 		}
 
-		return c.pool.Set(key, value, expires).Err()
+		return c.retry.do(true, func() error {
+			return c.pool.Set(key, b, expires).Err()
+		})
 	})
 
 }
 
 func (c *RedisCache) Get(key string, ptrValue interface{}) error {
-	b, err := c.pool.Get(key).Bytes()
+	return c.read(func(client *redis.Client) error {
+		return c.get(client, key, ptrValue)
+	}, key)
+}
+
+func (c *RedisCache) get(client *redis.Client, key string, ptrValue interface{}) error {
+	b, err := client.Get(key).Bytes()
 	if err == redis.Nil {
 		return ErrCacheMiss
 	}
@@ -203,11 +470,54 @@ func (c *RedisCache) Get(key string, ptrValue interface{}) error {
 		return err
 	}
 
-	return json.Unmarshal(b, ptrValue)
+	return decodeRedisValue(b, ptrValue)
+}
+
+func (c *RedisCache) SetNegative(key string, expires time.Duration) error {
+	if expires == DefaultExpiryTime {
+		expires = c.negativeExpiration
+	}
+
+	defer c.written(key)
+	return c.retry.do(true, func() error {
+		return c.pool.Set(key, redisTombstone, expires).Err()
+	})
+}
+
+var redisTouchScript = redis.NewScript(`
+local value = redis.call("get", KEYS[1])
+if not value or value == ARGV[2] then
+	return 0
+end
+if tonumber(ARGV[1]) > 0 then
+	return redis.call("pexpire", KEYS[1], ARGV[1])
+end
+return redis.call("persist", KEYS[1]) + 1
+`)
+
+// Touch implements Toucher through a Lua script, so that negative entries are
+// left alone.
+func (c *RedisCache) Touch(key string, expires time.Duration) error {
+	return c.retry.do(true, func() error {
+		res, err := redisTouchScript.Run(c.pool, []string{key}, int64(expires/time.Millisecond), redisTombstone).Result()
+		if err != nil {
+			return err
+		}
+
+		if n, _ := res.(int64); n == 0 {
+			return ErrNotStored
+		}
+		return nil
+	})
 }
 
 func (c *RedisCache) GetMulti(keys ...string) (Getter, error) {
-	res, err := c.pool.MGet(keys...).Result()
+	var res []interface{}
+	err := c.read(func(client *redis.Client) error {
+		var err error
+		res, err = client.MGet(keys...).Result()
+		return err
+	}, keys...)
 	if err != nil {
 		return nil, err
 	}
@@ -218,21 +528,56 @@ func (c *RedisCache) GetMulti(keys ...string) (Getter, error) {
 
 	m := make(map[string]string)
 	for ix, key := range keys {
-		m[key] = res[ix].(string)
+		// MGET returns nil for missing keys.
+		if s, ok := res[ix].(string); ok {
+			m[key] = s
+		}
 	}
 	return RedisItemMapGetter(m), nil
 }
 
 func (c *RedisCache) Delete(key string) error {
-	return c.pool.Del(key).Err()
+	defer c.written(key)
+	return c.retry.do(true, func() error {
+		return c.pool.Del(key).Err()
+	})
 }
 
 func (c *RedisCache) Keys() ([]string, error) {
-	return c.pool.Keys("*").Result()
+	var keys []string
+	err := c.readAll(func(client *redis.Client) error {
+		var err error
+		keys, err = client.Keys("*").Result()
+		return err
+	})
+	return keys, err
 }
 
 func (c *RedisCache) Flush() error {
-	return c.pool.FlushAll().Err()
+	if c.writes != nil {
+		defer c.writes.addAll()
+	}
+	err := c.retry.do(true, func() error {
+		return c.pool.FlushAll().Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Redis sends no notification for flushes.
+	c.events.handlers.emit(EventFlush, "")
+	return nil
+}
+
+// PoolStats returns the statistics of the connection pool to the primary.
+func (c *RedisCache) PoolStats() *redis.PoolStats {
+	return c.pool.PoolStats()
+}
+
+// Client returns the client of the primary, for the commands the Cache
+// interface does not cover. They are not retried.
+func (c *RedisCache) Client() *redis.Client {
+	return c.pool
 }
 
 // RedisItemMapGetter implements a Getter on top of the returned item map.
@@ -244,5 +589,5 @@ func (g RedisItemMapGetter) Get(key string, ptrValue interface{}) error {
 		return ErrCacheMiss
 	}
 
-	return json.Unmarshal([]byte(item), ptrValue)
+	return decodeRedisValue([]byte(item), ptrValue)
 }
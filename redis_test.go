@@ -6,6 +6,7 @@ package cache
 
 import (
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -71,6 +72,105 @@ func TestRedisCache_Keys(t *testing.T) {
 	testKeys(t, newRedisCache)
 }
 
+func TestRedisCache_Negative(t *testing.T) {
+	testNegative(t, newRedisCache)
+}
+
+func TestRedisCache_ConcurrentSetFields(t *testing.T) {
+	// Every writer takes the lock on the key, so they wait for it long enough
+	// for all of them to get it.
+	testConcurrentSetFields(t, func(t *testing.T, defaultExpiration time.Duration) Cache {
+		newRedisCache(t, defaultExpiration)
+		return NewRedisCache(RedisOpts{
+			Host:        redisTestServer,
+			Expiration:  defaultExpiration,
+			LockRetries: 1000,
+			LockBackoff: JitteredBackoff(ConstantBackoff(5 * time.Millisecond)),
+		})
+	})
+}
+
+func TestRedisCache_Touch(t *testing.T) {
+	testTouch(t, newRedisCache)
+}
+
+func TestRedisCache_Compression(t *testing.T) {
+	cache := newRedisCache(t, time.Hour).(*RedisCache)
+	cache.compression = FlateCompression
+	cache.compressionThreshold = 1
+
+	typicalGetSet(t, func(*testing.T, time.Duration) Cache { return cache })
+	testGetMulti(t, func(*testing.T, time.Duration) Cache { return cache })
+
+	// Values written before compression was turned on are still readable.
+	if err := cache.pool.Set("plain", `"foo"`, time.Hour).Err(); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	var value string
+	if err := cache.Get("plain", &value); err != nil || value != "foo" {
+		t.Errorf("Error getting an uncompressed value: %s / %s", err, value)
+	}
+}
+
+func TestRedisCache_Replicas(t *testing.T) {
+	newCache := func(t *testing.T, defaultExpiration time.Duration) Cache {
+		newRedisCache(t, defaultExpiration)
+
+		// The test server is its own replica, next to one which is down and
+		// whose reads fall back to the primary.
+		return NewRedisCache(RedisOpts{
+			Host:           redisTestServer,
+			Expiration:     defaultExpiration,
+			Replicas:       []string{redisTestServer, "localhost:1"},
+			ReadYourWrites: time.Second,
+		})
+	}
+
+	typicalGetSet(t, newCache)
+	testGetMulti(t, newCache)
+	testKeys(t, newCache)
+}
+
+func TestRedisDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	logger := &recordingLogger{}
+	d := newRedisDialer("tcp", addr, time.Second, logger)
+
+	// A server which is down is logged once, however often it is dialed.
+	for i := 0; i < 3; i++ {
+		if _, err := d.dial(); err == nil {
+			t.Fatalf("Expected dialing a closed port to fail")
+		}
+	}
+
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Skipf("Cannot listen on %s again: %s", addr, err)
+	}
+	defer l.Close()
+
+	// So is it coming back.
+	for i := 0; i < 3; i++ {
+		conn, err := d.dial()
+		if err != nil {
+			t.Fatalf("Error dialing: %s", err)
+		}
+		conn.Close()
+	}
+
+	entries := logger.Entries()
+	if len(entries) != 2 || !strings.HasPrefix(entries[0], "ERROR cache: cannot connect to redis") ||
+		!strings.HasPrefix(entries[1], "WARN cache: reconnected to redis") {
+		t.Errorf("Expected the outage to be logged, got %q", entries)
+	}
+}
+
 func TestRedisCache_LockRetry(t *testing.T) {
 
 	cache := newRedisCache(t, testExpiryTime)
@@ -106,3 +206,72 @@ func TestRedisCache_LockRetry(t *testing.T) {
 	assert.Equal(t, int64(1), counter)
 	assert.Equal(t, int64(1), errors)
 }
+
+var newRedisLocker = func(t *testing.T) Locker {
+	x := newRedisCache(t, time.Hour).(*RedisCache)
+	x.lockRetries = 1
+	return x
+}
+
+func TestRedisCache_Lock(t *testing.T) {
+	testLock(t, newRedisLocker)
+}
+
+func TestRedisCache_LockExpiry(t *testing.T) {
+	testLockExpiry(t, newRedisLocker)
+}
+
+func TestRedisCache_LockRefresh(t *testing.T) {
+	testLockRefresh(t, newRedisLocker)
+}
+
+func TestRedisBus_Invalidation(t *testing.T) {
+	remote := newRedisCache(t, time.Hour).(*RedisCache)
+
+	busA, err := NewRedisBus(remote, "invalidations")
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err)
+	}
+	defer busA.Close()
+
+	busB, err := NewRedisBus(remote, "invalidations")
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err)
+	}
+	defer busB.Close()
+
+	testInvalidation(t, remote, busA, busB)
+}
+
+func TestRedisBus_Undecodable(t *testing.T) {
+	logger := &recordingLogger{}
+	remote := NewRedisCache(RedisOpts{Host: redisTestServer, Logger: logger})
+	bus, err := NewRedisBus(remote, "invalidations")
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err)
+	}
+	defer bus.Close()
+
+	received := make(chan Invalidation, 1)
+	bus.Subscribe(func(inv Invalidation) { received <- inv })
+
+	// A message which is not an invalidation is reported and skipped.
+	remote.pool.Publish("invalidations", "not json")
+	if err := bus.Publish(Invalidation{Source: "a", Keys: []string{"k"}}); err != nil {
+		t.Fatalf("Error publishing: %s", err)
+	}
+
+	select {
+	case inv := <-received:
+		if inv.Source != "a" {
+			t.Errorf("Expected the invalidation, got %v", inv)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the invalidation to be received")
+	}
+
+	entries := logger.Entries()
+	if len(entries) != 1 || !strings.HasPrefix(entries[0], "WARN cache: skipping an undecodable invalidation [channel invalidations error ") {
+		t.Errorf("Expected the message to be logged, got %v", entries)
+	}
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ReplicaSelection picks which replica serves a read.
+type ReplicaSelection int
+
+const (
+	// RoundRobin spreads reads evenly over the replicas.
+	RoundRobin ReplicaSelection = iota
+	// LowestLatency sends reads to the replica which has been answering the
+	// fastest lately.
+	LowestLatency
+)
+
+// replicaProbeInterval is how often LowestLatency reads from a replica it
+// would not pick otherwise, to measure its latency again.
+const replicaProbeInterval = 5 * time.Second
+
+// redisReplicas are the read replicas of a RedisCache.
+type redisReplicas struct {
+	clients       []*redis.Client
+	selection     ReplicaSelection
+	next          uint32
+	penalty       time.Duration // Latency recorded for a failed read.
+	probeInterval time.Duration
+
+	mu        sync.Mutex
+	latencies []time.Duration // Moving averages of the read latencies.
+	measured  []time.Time     // When each replica was last read from.
+}
+
+func newRedisReplicas(clients []*redis.Client, selection ReplicaSelection, penalty time.Duration) *redisReplicas {
+	return &redisReplicas{
+		clients:       clients,
+		selection:     selection,
+		penalty:       penalty,
+		probeInterval: replicaProbeInterval,
+		latencies:     make([]time.Duration, len(clients)),
+		measured:      make([]time.Time, len(clients)),
+	}
+}
+
+// pick returns the index of the replica to read from.
+func (r *redisReplicas) pick() int {
+	if r.selection == LowestLatency {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		now := time.Now()
+		best := 0
+		for i, latency := range r.latencies {
+			// A replica which was slow or failing would otherwise never be
+			// read from again, and never be seen to recover.
+			if now.Sub(r.measured[i]) >= r.probeInterval {
+				best = i
+				break
+			}
+
+			if latency < r.latencies[best] {
+				best = i
+			}
+		}
+
+		r.measured[best] = now
+		return best
+	}
+
+	return int(atomic.AddUint32(&r.next, 1)-1) % len(r.clients)
+}
+
+// observe records how long a read from replica i took.
+func (r *redisReplicas) observe(i int, d time.Duration, err error) {
+	if r.selection != LowestLatency {
+		return
+	}
+
+	if err != nil && err != redis.Nil {
+		d = r.penalty
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.measured[i] = time.Now()
+	if r.latencies[i] == 0 {
+		r.latencies[i] = d
+		return
+	}
+	r.latencies[i] += (d - r.latencies[i]) / 8
+}
+
+// recentWrites remembers which keys were written lately, so that reads of
+// them can go to the primary until the replicas have caught up.
+type recentWrites struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]time.Time
+	flushed   time.Time
+	lastPrune time.Time
+}
+
+func newRecentWrites(window time.Duration) *recentWrites {
+	return &recentWrites{window: window, keys: map[string]time.Time{}}
+}
+
+// add records a write of key.
+func (w *recentWrites) add(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	w.keys[key] = now
+
+	if now.Sub(w.lastPrune) < w.window {
+		return
+	}
+
+	for key, at := range w.keys {
+		if now.Sub(at) >= w.window {
+			delete(w.keys, key)
+		}
+	}
+	w.lastPrune = now
+}
+
+// addAll records a write of every key.
+func (w *recentWrites) addAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.flushed = time.Now()
+	w.keys = map[string]time.Time{}
+}
+
+// recent reports whether any of keys, or every key, was written lately.
+func (w *recentWrites) recent(keys ...string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(w.flushed) < w.window {
+		return true
+	}
+
+	for _, key := range keys {
+		if at, ok := w.keys[key]; ok && now.Sub(at) < w.window {
+			return true
+		}
+	}
+	return false
+}
+
+// recentAny reports whether any key at all was written lately.
+func (w *recentWrites) recentAny() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(w.flushed) < w.window {
+		return true
+	}
+
+	for _, at := range w.keys {
+		if now.Sub(at) < w.window {
+			return true
+		}
+	}
+	return false
+}
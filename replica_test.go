@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestRedisReplicas_RoundRobin(t *testing.T) {
+	r := newRedisReplicas(make([]*redis.Client, 3), RoundRobin, time.Second)
+
+	counts := make([]int, 3)
+	for i := 0; i < 30; i++ {
+		counts[r.pick()]++
+	}
+
+	for i, n := range counts {
+		if n != 10 {
+			t.Errorf("Expected 10 reads from replica %d, got %d", i, n)
+		}
+	}
+}
+
+func TestRedisReplicas_LowestLatency(t *testing.T) {
+	r := newRedisReplicas(make([]*redis.Client, 3), LowestLatency, time.Second)
+
+	r.observe(0, 10*time.Millisecond, nil)
+	r.observe(1, time.Millisecond, nil)
+	r.observe(2, 5*time.Millisecond, redis.Nil)
+	if i := r.pick(); i != 1 {
+		t.Errorf("Expected the fastest replica, got %d", i)
+	}
+
+	// Failing reads count as slow ones.
+	for i := 0; i < 20; i++ {
+		r.observe(1, time.Millisecond, errors.New("connection refused"))
+	}
+	if i := r.pick(); i != 2 {
+		t.Errorf("Expected the fastest healthy replica, got %d", i)
+	}
+}
+
+func TestRedisReplicas_LowestLatencyProbes(t *testing.T) {
+	r := newRedisReplicas(make([]*redis.Client, 2), LowestLatency, time.Second)
+	r.probeInterval = 20 * time.Millisecond
+
+	r.observe(0, time.Millisecond, nil)
+	r.observe(1, time.Millisecond, errors.New("connection refused"))
+	if i := r.pick(); i != 0 {
+		t.Errorf("Expected the fastest replica, got %d", i)
+	}
+
+	// Once not read from for a while, the slow replica is read from again.
+	time.Sleep(30 * time.Millisecond)
+	picked := map[int]bool{r.pick(): true, r.pick(): true}
+	if !picked[1] {
+		t.Errorf("Expected the slow replica to be probed, got %v", picked)
+	}
+
+	if i := r.pick(); i != 0 {
+		t.Errorf("Expected reads back on the fastest replica, got %d", i)
+	}
+}
+
+func TestRecentWrites(t *testing.T) {
+	w := newRecentWrites(50 * time.Millisecond)
+
+	w.add("a")
+	if !w.recent("b", "a") {
+		t.Errorf("Expected a to be recent")
+	}
+
+	if w.recent("b") || w.recent() {
+		t.Errorf("Expected b not to be recent")
+	}
+
+	if !w.recentAny() {
+		t.Errorf("Expected some key to be recent")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	w.add("b")
+	if w.recent("a") {
+		t.Errorf("Expected a not to be recent anymore")
+	}
+
+	if _, ok := w.keys["a"]; ok {
+		t.Errorf("Expected a to be pruned")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if w.recentAny() {
+		t.Errorf("Expected no key to be recent anymore")
+	}
+
+	w.addAll()
+	if !w.recent() || !w.recent("c") {
+		t.Errorf("Expected every key to be recent after a flush")
+	}
+}
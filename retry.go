@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+const defaultRetryAttempts = 3
+
+var defaultRetryBackoff = JitteredBackoff(ExponentialBackoff(10*time.Millisecond, 200*time.Millisecond))
+
+// RetryPolicy decides which failed Redis commands are tried again, and when.
+//
+// Only commands which can safely run twice are retried by default: a command
+// whose reply was lost may well have been applied. Taking a lock is not one of
+// them, so Add, Replace and SetFields retry the commands they run while
+// holding the lock, but not the taking of the lock itself.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a command is tried in all. One disables
+	// retries.
+	MaxAttempts int
+	// Backoff is how long to wait before each retry.
+	Backoff Backoff
+	// Retryable reports whether err is worth retrying. Defaults to network
+	// errors.
+	Retryable func(err error) bool
+	// RetryNonIdempotent also retries the commands which may not be safe to
+	// run twice.
+	RetryNonIdempotent bool
+
+	logger Logger
+}
+
+func (p RetryPolicy) padDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaultRetryAttempts
+	}
+
+	if p.Backoff == nil {
+		p.Backoff = defaultRetryBackoff
+	}
+
+	if p.Retryable == nil {
+		p.Retryable = isNetworkError
+	}
+
+	p.logger = loggerOrNop(p.logger)
+	return p
+}
+
+func isNetworkError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// do runs op, trying again as the policy allows while it fails.
+func (p RetryPolicy) do(idempotent bool, op func() error) error {
+	attempts := p.MaxAttempts
+	if !idempotent && !p.RetryNonIdempotent {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= attempts || !p.Retryable(err) {
+			return err
+		}
+
+		p.logger.Warn("cache: retrying after a transient error", "attempt", attempt, "error", err)
+		time.Sleep(p.Backoff(attempt))
+	}
+}
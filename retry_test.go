@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, Backoff: ConstantBackoff(time.Millisecond)}.padDefaults()
+
+	failing := func(err error, calls *int) func() error {
+		return func() error {
+			*calls++
+			return err
+		}
+	}
+
+	var calls int
+	if err := p.do(true, failing(io.EOF, &calls)); err != io.EOF || calls != 3 {
+		t.Errorf("Expected 3 attempts on a network error, got %d: %v", calls, err)
+	}
+
+	calls = 0
+	if err := p.do(false, failing(io.EOF, &calls)); err != io.EOF || calls != 1 {
+		t.Errorf("Expected a non-idempotent command to be tried once, got %d", calls)
+	}
+
+	calls = 0
+	errOther := errors.New("WRONGTYPE")
+	if err := p.do(true, failing(errOther, &calls)); err != errOther || calls != 1 {
+		t.Errorf("Expected other errors not to be retried, got %d", calls)
+	}
+
+	calls = 0
+	err := p.do(true, func() error {
+		calls++
+		if calls < 2 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("Expected success on the second attempt, got %d: %v", calls, err)
+	}
+
+	p.RetryNonIdempotent = true
+	calls = 0
+	if err := p.do(false, failing(io.EOF, &calls)); err != io.EOF || calls != 3 {
+		t.Errorf("Expected 3 attempts when asked to retry anything, got %d", calls)
+	}
+}
@@ -0,0 +1,298 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Loader loads the value of key from its source of truth, for when it is
+// missing from the cache or due to be refreshed.
+type Loader func(key string) (interface{}, error)
+
+// RevalidateOpts configures a RevalidatingCache.
+type RevalidateOpts struct {
+	// TTL is how long a loaded value is fresh.
+	TTL time.Duration
+
+	// StaleTTL is how long a value is still served after it stopped being
+	// fresh, while it is being refreshed in the background.
+	StaleTTL time.Duration
+
+	// RefreshAhead refreshes values in the background when they are read
+	// within this window before they stop being fresh. Zero disables it.
+	RefreshAhead time.Duration
+
+	// Logger receives the errors and panics of background refreshes.
+	Logger Logger
+
+	// Clock decides when values stop being fresh. Defaults to the system
+	// clock.
+	Clock Clock
+}
+
+// RevalidatingCache wraps a Cache so that a hot key never makes its readers
+// wait for the loader once it has been loaded: every item carries a soft
+// expiry, after which it is still served for StaleTTL while a single
+// background load refreshes it.
+//
+// Items are removed from the wrapped cache once they are past both TTLs.
+type RevalidatingCache struct {
+	cache Cache
+	opts  RevalidateOpts
+
+	loads      loadGroup
+	mu         sync.Mutex
+	refreshing map[string]bool
+}
+
+// revalidatingItem is what a RevalidatingCache stores in the wrapped cache.
+type revalidatingItem struct {
+	Value json.RawMessage `json:"v"`
+	// SoftExpiry is when the value stops being fresh, in Unix nanoseconds.
+	// Zero means never.
+	SoftExpiry int64 `json:"s,omitempty"`
+}
+
+func NewRevalidatingCache(c Cache, opts RevalidateOpts) *RevalidatingCache {
+	opts.Logger = loggerOrNop(opts.Logger)
+	if opts.Clock == nil {
+		opts.Clock = SystemClock{}
+	}
+	return &RevalidatingCache{
+		cache:      c,
+		opts:       opts,
+		refreshing: map[string]bool{},
+	}
+}
+
+// wrap turns value into an item that is fresh for expires, and returns how
+// long the wrapped cache should keep it.
+func (c *RevalidatingCache) wrap(value interface{}, expires time.Duration) (revalidatingItem, time.Duration, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return revalidatingItem{}, 0, err
+	}
+
+	if expires == DefaultExpiryTime {
+		expires = c.opts.TTL
+	}
+
+	item := revalidatingItem{Value: b}
+	if expires <= 0 {
+		return item, ForEverNeverExpiry, nil
+	}
+
+	item.SoftExpiry = c.opts.Clock.Now().Add(expires).UnixNano()
+	return item, expires + c.opts.StaleTTL, nil
+}
+
+func (c *RevalidatingCache) Get(key string, ptrValue interface{}) error {
+	var item revalidatingItem
+	if err := c.cache.Get(key, &item); err != nil {
+		return err
+	}
+	return json.Unmarshal(item.Value, ptrValue)
+}
+
+// GetOrLoad gets the value of key, calling load when it is missing. Callers
+// asking for the same missing key at the same time share a single load. If
+// it panics, the panic goes on up the caller whose load it was, and the others
+// get ErrLoadPanicked.
+//
+// Stale values, and fresh values read within RefreshAhead of going stale,
+// are returned right away and refreshed in the background.
+func (c *RevalidatingCache) GetOrLoad(key string, ptrValue interface{}, load Loader) error {
+	var item revalidatingItem
+	err := c.cache.Get(key, &item)
+	if err == ErrCacheMiss {
+		b, err := c.loads.do(key, func() (json.RawMessage, error) {
+			return c.load(key, load)
+		})
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, ptrValue)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if item.SoftExpiry != 0 {
+		refreshAt := time.Unix(0, item.SoftExpiry).Add(-c.opts.RefreshAhead)
+		if !c.opts.Clock.Now().Before(refreshAt) {
+			c.refresh(key, load)
+		}
+	}
+	return json.Unmarshal(item.Value, ptrValue)
+}
+
+// load calls the loader and stores what it returned.
+func (c *RevalidatingCache) load(key string, load Loader) (json.RawMessage, error) {
+	value, err := load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	item, expires, err := c.wrap(value, c.opts.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.cache.Set(key, item, expires); err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+// refresh reloads key in the background, unless it is already being
+// refreshed. A panic of the loader is logged rather than left to crash the
+// process.
+func (c *RevalidatingCache) refresh(key string, load Loader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing[key] {
+		return
+	}
+
+	c.refreshing[key] = true
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.opts.Logger.Error("cache: background refresh panicked", "key", key, "panic", r)
+			}
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			delete(c.refreshing, key)
+		}()
+
+		if _, err := c.load(key, load); err != nil {
+			c.opts.Logger.Error("cache: background refresh failed", "key", key, "error", err)
+		}
+	}()
+}
+
+func (c *RevalidatingCache) GetMulti(keys ...string) (Getter, error) {
+	g, err := c.cache.GetMulti(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return revalidatingGetter{g}, nil
+}
+
+func (c *RevalidatingCache) Set(key string, value interface{}, expires time.Duration) error {
+	item, expires, err := c.wrap(value, expires)
+	if err != nil {
+		return err
+	}
+	return c.cache.Set(key, item, expires)
+}
+
+// SetFields reads, merges and writes back the item, so unlike Set it is not
+// atomic.
+func (c *RevalidatingCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	existing := map[string]interface{}{}
+	if err := c.Get(key, &existing); err != nil {
+		if err == ErrCacheMiss || err == ErrNegativeHit {
+			return ErrNotStored
+		}
+		return err
+	}
+
+	for k, v := range value {
+		existing[k] = v
+	}
+	return c.Set(key, existing, expires)
+}
+
+func (c *RevalidatingCache) Add(key string, value interface{}, expires time.Duration) error {
+	item, expires, err := c.wrap(value, expires)
+	if err != nil {
+		return err
+	}
+	return c.cache.Add(key, item, expires)
+}
+
+func (c *RevalidatingCache) Replace(key string, value interface{}, expires time.Duration) error {
+	item, expires, err := c.wrap(value, expires)
+	if err != nil {
+		return err
+	}
+	return c.cache.Replace(key, item, expires)
+}
+
+func (c *RevalidatingCache) Delete(key string) error {
+	return c.cache.Delete(key)
+}
+
+func (c *RevalidatingCache) Flush() error {
+	return c.cache.Flush()
+}
+
+func (c *RevalidatingCache) Keys() ([]string, error) {
+	return c.cache.Keys()
+}
+
+// revalidatingGetter implements a Getter unwrapping the items of a
+// RevalidatingCache.
+type revalidatingGetter struct {
+	Getter
+}
+
+func (g revalidatingGetter) Get(key string, ptrValue interface{}) error {
+	var item revalidatingItem
+	if err := g.Getter.Get(key, &item); err != nil {
+		return err
+	}
+	return json.Unmarshal(item.Value, ptrValue)
+}
+
+// loadGroup makes concurrent loads of the same key share a single call.
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*loadCall
+}
+
+type loadCall struct {
+	wg    sync.WaitGroup
+	value json.RawMessage
+	err   error
+}
+
+func (g *loadGroup) do(key string, fn func() (json.RawMessage, error)) (json.RawMessage, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*loadCall{}
+	}
+
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+
+	call := &loadCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		// If fn panicked, the panic goes on up this caller and the others
+		// waiting get an error.
+		if !returned {
+			call.err = ErrLoadPanicked
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	call.value, call.err = fn()
+	returned = true
+	return call.value, call.err
+}
@@ -0,0 +1,270 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var newRevalidatingCache = func(t *testing.T, defaultExpiration time.Duration) Cache {
+	return NewRevalidatingCache(newInMemoryCache(t, defaultExpiration), RevalidateOpts{
+		TTL:      defaultExpiration,
+		StaleTTL: 500 * time.Millisecond,
+		Clock:    newFakeClock(),
+	})
+}
+
+// newRevalidatingTestCache returns a RevalidatingCache over an InMemoryCache,
+// both on clock.
+func newRevalidatingTestCache(clock Clock, opts RevalidateOpts) *RevalidatingCache {
+	opts.Clock = clock
+	return NewRevalidatingCache(NewInMemoryCacheWithOpts(InMemoryOpts{Expiration: time.Hour, Clock: clock}), opts)
+}
+
+// waitRefreshes waits for the background refreshes of c to be done.
+func waitRefreshes(c *RevalidatingCache) {
+	for {
+		c.mu.Lock()
+		n := len(c.refreshing)
+		c.mu.Unlock()
+
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRevalidatingCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newRevalidatingCache)
+}
+
+func TestRevalidatingCache_SetFields(t *testing.T) {
+	testSetFields(t, newRevalidatingCache)
+}
+
+func TestRevalidatingCache_Expiration(t *testing.T) {
+	expiration(t, newRevalidatingCache)
+}
+
+func TestRevalidatingCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newRevalidatingCache)
+}
+
+func TestRevalidatingCache_Replace(t *testing.T) {
+	testReplace(t, newRevalidatingCache)
+}
+
+func TestRevalidatingCache_Add(t *testing.T) {
+	testAdd(t, newRevalidatingCache)
+}
+
+func TestRevalidatingCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newRevalidatingCache)
+}
+
+func TestRevalidatingCache_Keys(t *testing.T) {
+	testKeys(t, newRevalidatingCache)
+}
+
+// countingLoader returns how many times it was called.
+func countingLoader(calls *int64, delay time.Duration) Loader {
+	return func(key string) (interface{}, error) {
+		n := atomic.AddInt64(calls, 1)
+		time.Sleep(delay)
+		return n, nil
+	}
+}
+
+func TestRevalidatingCache_LoadOnce(t *testing.T) {
+	cache := NewRevalidatingCache(NewInMemoryCache(time.Hour), RevalidateOpts{TTL: time.Hour})
+
+	var calls int64
+	load := countingLoader(&calls, 100*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var n int64
+			if err := cache.GetOrLoad("value", &n, load); err != nil || n != 1 {
+				t.Errorf("Expected the first load: %v / %d", err, n)
+			}
+		}()
+	}
+
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("Expected a single load, got %d", calls)
+	}
+}
+
+func TestRevalidatingCache_LoadPanics(t *testing.T) {
+	cache := NewRevalidatingCache(NewInMemoryCache(time.Hour), RevalidateOpts{TTL: time.Hour})
+
+	release := make(chan struct{})
+	panicking := func(string) (interface{}, error) {
+		<-release
+		panic("boom")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("Expected the panic of the loader, got %v", r)
+			}
+		}()
+		cache.GetOrLoad("value", new(int64), panicking)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		defer wg.Done()
+		if err := cache.GetOrLoad("value", new(int64), panicking); err != ErrLoadPanicked {
+			t.Errorf("Expected ErrLoadPanicked while waiting, got %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	var calls int64
+	var n int64
+	if err := cache.GetOrLoad("value", &n, countingLoader(&calls, 0)); err != nil || n != 1 {
+		t.Errorf("Expected the key to load again after a panic: %v / %d", err, n)
+	}
+}
+
+func TestRevalidatingCache_StaleWhileRevalidate(t *testing.T) {
+	clock := newFakeClock()
+	cache := newRevalidatingTestCache(clock, RevalidateOpts{
+		TTL:      time.Second,
+		StaleTTL: time.Hour,
+	})
+
+	// Refreshes wait until released, so that they are still running while
+	// the stale value is read.
+	var calls int64
+	release := make(chan struct{})
+	load := func(string) (interface{}, error) {
+		n := atomic.AddInt64(&calls, 1)
+		if n > 1 {
+			<-release
+		}
+		return n, nil
+	}
+
+	var n int64
+	if err := cache.GetOrLoad("value", &n, load); err != nil || n != 1 {
+		t.Fatalf("Expected the first load: %v / %d", err, n)
+	}
+
+	// Once stale, every reader gets the stale value without waiting, and
+	// a single refresh runs in the background.
+	clock.Advance(time.Second)
+	for i := 0; i < 10; i++ {
+		if err := cache.GetOrLoad("value", &n, load); err != nil || n != 1 {
+			t.Errorf("Expected the stale value: %v / %d", err, n)
+		}
+	}
+
+	close(release)
+	waitRefreshes(cache)
+	if err := cache.GetOrLoad("value", &n, load); err != nil || n != 2 {
+		t.Errorf("Expected the refreshed value: %v / %d", err, n)
+	}
+
+	if calls != 2 {
+		t.Errorf("Expected two loads, got %d", calls)
+	}
+}
+
+func TestRevalidatingCache_RefreshAhead(t *testing.T) {
+	clock := newFakeClock()
+	cache := newRevalidatingTestCache(clock, RevalidateOpts{
+		TTL:          time.Second,
+		RefreshAhead: 500 * time.Millisecond,
+	})
+
+	var calls int64
+	load := countingLoader(&calls, 0)
+
+	var n int64
+	if err := cache.GetOrLoad("value", &n, load); err != nil || n != 1 {
+		t.Fatalf("Expected the first load: %v / %d", err, n)
+	}
+
+	// Outside the window nothing happens.
+	if err := cache.GetOrLoad("value", &n, load); err != nil || n != 1 {
+		t.Errorf("Expected the loaded value: %v / %d", err, n)
+	}
+
+	// Inside it the value is refreshed before it ever goes stale.
+	clock.Advance(600 * time.Millisecond)
+	if err := cache.GetOrLoad("value", &n, load); err != nil || n != 1 {
+		t.Errorf("Expected the loaded value: %v / %d", err, n)
+	}
+
+	waitRefreshes(cache)
+	if err := cache.GetOrLoad("value", &n, load); err != nil || n != 2 {
+		t.Errorf("Expected the refreshed value: %v / %d", err, n)
+	}
+}
+
+func TestRevalidatingCache_LogsRefreshErrors(t *testing.T) {
+	clock := newFakeClock()
+	logger := &recordingLogger{}
+	cache := newRevalidatingTestCache(clock, RevalidateOpts{
+		TTL:      100 * time.Millisecond,
+		StaleTTL: time.Hour,
+		Logger:   logger,
+	})
+
+	var n int
+	cache.GetOrLoad("value", &n, func(string) (interface{}, error) { return 1, nil })
+	clock.Advance(150 * time.Millisecond)
+
+	cache.GetOrLoad("value", &n, func(string) (interface{}, error) {
+		return nil, errors.New("database is down")
+	})
+	waitRefreshes(cache)
+
+	// A panic of the loader is logged too, and the key refreshed again later.
+	cache.GetOrLoad("value", &n, func(string) (interface{}, error) {
+		panic("boom")
+	})
+	waitRefreshes(cache)
+
+	cache.GetOrLoad("value", &n, func(string) (interface{}, error) { return 2, nil })
+	waitRefreshes(cache)
+	if err := cache.Get("value", &n); err != nil || n != 2 {
+		t.Errorf("Expected the refreshed value: %v / %d", err, n)
+	}
+
+	expected := []string{
+		"ERROR cache: background refresh failed [key value error database is down]",
+		"ERROR cache: background refresh panicked [key value panic boom]",
+	}
+	if entries := logger.Entries(); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected the refresh errors to be logged, got %v", entries)
+	}
+}
+
+func TestRevalidatingCache_SetFieldsNegative(t *testing.T) {
+	inner := NewInMemoryCache(time.Hour)
+	cache := NewRevalidatingCache(inner, RevalidateOpts{TTL: time.Hour})
+
+	inner.SetNegative("missing", time.Hour)
+	if err := cache.SetFields("missing", map[string]interface{}{"field": 1}, time.Hour); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored setting fields of a tombstone: %v", err)
+	}
+}
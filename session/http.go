@@ -0,0 +1,59 @@
+package session
+
+import "net/http"
+
+// Get returns the session of the cookie of r, ErrNoSession if it has none.
+func (s *Store) Get(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(s.opts.CookieName)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	return s.Load(cookie.Value)
+}
+
+// Start returns the session of the cookie of r, or creates one if it has
+// none. Either way, it sets the cookie on w so that it expires along with the
+// session.
+func (s *Store) Start(w http.ResponseWriter, r *http.Request) (*Session, error) {
+	sess, err := s.Get(r)
+	if err == ErrNoSession {
+		sess, err = s.Create(nil)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.SetCookie(w, sess)
+	return sess, nil
+}
+
+// End destroys the session of the cookie of r, if any, and deletes the cookie.
+func (s *Store) End(w http.ResponseWriter, r *http.Request) error {
+	if cookie, err := r.Cookie(s.opts.CookieName); err == nil {
+		if err := s.Destroy(cookie.Value); err != nil {
+			return err
+		}
+	}
+
+	http.SetCookie(w, s.cookie("", -1))
+	return nil
+}
+
+// SetCookie sets the cookie of sess on w.
+func (s *Store) SetCookie(w http.ResponseWriter, sess *Session) {
+	http.SetCookie(w, s.cookie(sess.ID, int(s.opts.MaxAge.Seconds())))
+}
+
+func (s *Store) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.opts.CookieName,
+		Value:    value,
+		Path:     s.opts.CookiePath,
+		Domain:   s.opts.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   s.opts.Secure,
+		HttpOnly: true,
+		SameSite: s.opts.SameSite,
+	}
+}
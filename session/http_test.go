@@ -0,0 +1,58 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+func TestStore_HTTP(t *testing.T) {
+	s := NewStore(cache.NewInMemoryCache(time.Hour), Opts{Secure: true})
+
+	w := httptest.NewRecorder()
+	sess, err := s.Start(w, httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Error starting a session: %s", err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != sess.ID || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].MaxAge != 1800 {
+		t.Fatalf("Unexpected cookies %v", cookies)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	again, err := s.Start(httptest.NewRecorder(), r)
+	if err != nil || again.ID != sess.ID {
+		t.Fatalf("Expected the session of the cookie, got %v / %v", err, again)
+	}
+
+	w = httptest.NewRecorder()
+	if err := s.End(w, r); err != nil {
+		t.Fatalf("Error ending the session: %s", err)
+	}
+
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected the cookie to be deleted, got %v", cookies)
+	}
+
+	if _, err := s.Get(r); err != ErrNoSession {
+		t.Errorf("Expected the session to be destroyed, got %v", err)
+	}
+}
+
+func TestStore_GetWithoutCookie(t *testing.T) {
+	s := NewStore(cache.NewInMemoryCache(time.Hour), Opts{})
+	if _, err := s.Get(httptest.NewRequest("GET", "/", nil)); err != ErrNoSession {
+		t.Errorf("Expected no session, got %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "forged"})
+	if _, err := s.Get(r); err != ErrNoSession {
+		t.Errorf("Expected no session for a forged cookie, got %v", err)
+	}
+}
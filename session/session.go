@@ -0,0 +1,187 @@
+// Package session stores HTTP sessions in a cache.Cache, as revel does.
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+// ErrNoSession is returned for a session which does not exist, or has
+// expired or been destroyed.
+var ErrNoSession = errors.New("session: no session")
+
+const (
+	defaultCookieName = "session"
+	defaultMaxAge     = 30 * time.Minute
+	keyPrefix         = "session:"
+	idBytes           = 32
+)
+
+// Opts configures a Store.
+type Opts struct {
+	// MaxAge is how long a session lives without being used. Defaults to 30
+	// minutes.
+	MaxAge time.Duration
+	// CookieName defaults to "session".
+	CookieName string
+	// CookiePath defaults to "/".
+	CookiePath   string
+	CookieDomain string
+	// Secure only sends the cookie over HTTPS.
+	Secure bool
+	// SameSite defaults to http.SameSiteLaxMode.
+	SameSite http.SameSite
+}
+
+func (o Opts) padDefaults() Opts {
+	if o.MaxAge == 0 {
+		o.MaxAge = defaultMaxAge
+	}
+
+	if o.CookieName == "" {
+		o.CookieName = defaultCookieName
+	}
+
+	if o.CookiePath == "" {
+		o.CookiePath = "/"
+	}
+
+	if o.SameSite == 0 {
+		o.SameSite = http.SameSiteLaxMode
+	}
+	return o
+}
+
+// Session is the data of a client, kept between its requests.
+type Session struct {
+	ID string
+	// Values are decoded from JSON, so numbers come back as float64.
+	Values map[string]interface{}
+}
+
+// Store creates, loads, saves and destroys sessions. Sessions expire once
+// they have not been loaded or saved for MaxAge.
+type Store struct {
+	cache cache.Cache
+	opts  Opts
+}
+
+// NewStore returns a Store keeping sessions in c.
+func NewStore(c cache.Cache, opts Opts) *Store {
+	return &Store{cache: c, opts: opts.padDefaults()}
+}
+
+func key(id string) string {
+	return keyPrefix + id
+}
+
+// newID returns a random session ID, unguessable and safe in a cookie.
+func newID() (string, error) {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validID reports whether id may have been returned by newID, so that
+// arbitrary cookies are not looked up.
+func validID(id string) bool {
+	if len(id) != base64.RawURLEncoding.EncodedLen(idBytes) {
+		return false
+	}
+
+	_, err := base64.RawURLEncoding.DecodeString(id)
+	return err == nil
+}
+
+// encode returns values as stored. Encoding them up front keeps in-process
+// caches from holding on to the map the caller goes on changing.
+func encode(values map[string]interface{}) (json.RawMessage, error) {
+	b, err := json.Marshal(values)
+	return json.RawMessage(b), err
+}
+
+// notFound turns the errors of a missing session into ErrNoSession.
+func notFound(err error) error {
+	if err == cache.ErrCacheMiss || err == cache.ErrNotStored {
+		return ErrNoSession
+	}
+	return err
+}
+
+// Create starts a new session holding values.
+func (s *Store) Create(values map[string]interface{}) (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+
+	b, err := encode(values)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.cache.Add(key(id), b, s.opts.MaxAge); err != nil {
+		return nil, err
+	}
+	return &Session{ID: id, Values: values}, nil
+}
+
+// Load returns the session id, and extends its life.
+func (s *Store) Load(id string) (*Session, error) {
+	if !validID(id) {
+		return nil, ErrNoSession
+	}
+
+	values := map[string]interface{}{}
+	if err := s.cache.Get(key(id), &values); err != nil {
+		return nil, notFound(err)
+	}
+
+	if err := s.touch(key(id)); err != nil {
+		return nil, notFound(err)
+	}
+	return &Session{ID: id, Values: values}, nil
+}
+
+// touch extends the life of the session at key, without writing it again if
+// the cache can. Otherwise, setting no field extends it without racing other
+// updates.
+func (s *Store) touch(key string) error {
+	if t, ok := s.cache.(cache.Toucher); ok {
+		return t.Touch(key, s.opts.MaxAge)
+	}
+	return s.cache.SetFields(key, map[string]interface{}{}, s.opts.MaxAge)
+}
+
+// Save stores every value of sess, and extends its life. Sessions which have
+// expired or been destroyed are not brought back.
+func (s *Store) Save(sess *Session) error {
+	b, err := encode(sess.Values)
+	if err != nil {
+		return err
+	}
+	return notFound(s.cache.Replace(key(sess.ID), b, s.opts.MaxAge))
+}
+
+// SetFields updates only the given values of session id, leaving the others
+// as concurrent requests may have set them, and extends its life.
+func (s *Store) SetFields(id string, values map[string]interface{}) error {
+	return notFound(s.cache.SetFields(key(id), values, s.opts.MaxAge))
+}
+
+// Destroy deletes session id.
+func (s *Store) Destroy(id string) error {
+	return s.cache.Delete(key(id))
+}
@@ -0,0 +1,169 @@
+package session
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+)
+
+// These tests require redis server running on localhost:6379 (the default)
+func backends(t *testing.T) map[string]cache.Cache {
+	redis := cache.NewRedisCache(cache.RedisOpts{Host: "localhost:6379"})
+	if err := redis.Flush(); err != nil {
+		t.Fatalf("couldn't connect to redis: %s", err)
+	}
+
+	return map[string]cache.Cache{
+		"memory": cache.NewInMemoryCache(time.Hour),
+		"redis":  redis,
+	}
+}
+
+func TestStore(t *testing.T) {
+	for name, c := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := NewStore(c, Opts{})
+
+			sess, err := s.Create(map[string]interface{}{"user": "alice"})
+			if err != nil {
+				t.Fatalf("Error creating a session: %s", err)
+			}
+
+			sess.Values["cart"] = 3.0
+			if err := s.Save(sess); err != nil {
+				t.Fatalf("Error saving the session: %s", err)
+			}
+
+			loaded, err := s.Load(sess.ID)
+			if err != nil || loaded.Values["user"] != "alice" || loaded.Values["cart"] != 3.0 {
+				t.Fatalf("Error loading the session: %s / %v", err, loaded)
+			}
+
+			if err := s.Destroy(sess.ID); err != nil {
+				t.Fatalf("Error destroying the session: %s", err)
+			}
+
+			if _, err := s.Load(sess.ID); err != ErrNoSession {
+				t.Errorf("Expected the destroyed session to be gone, got %v", err)
+			}
+
+			if err := s.Save(sess); err != ErrNoSession {
+				t.Errorf("Expected saving a destroyed session to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_IDs(t *testing.T) {
+	s := NewStore(cache.NewInMemoryCache(time.Hour), Opts{})
+
+	ids := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		sess, err := s.Create(nil)
+		if err != nil {
+			t.Fatalf("Error creating a session: %s", err)
+		}
+
+		if ids[sess.ID] || !validID(sess.ID) {
+			t.Fatalf("Unexpected session ID %q", sess.ID)
+		}
+		ids[sess.ID] = true
+	}
+
+	if _, err := s.Load("session:../../etc"); err != ErrNoSession {
+		t.Errorf("Expected invalid IDs not to be looked up, got %v", err)
+	}
+}
+
+func TestStore_SetFields(t *testing.T) {
+	for name, c := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := NewStore(c, Opts{})
+			sess, err := s.Create(map[string]interface{}{"user": "alice"})
+			if err != nil {
+				t.Fatalf("Error creating a session: %s", err)
+			}
+
+			var wg sync.WaitGroup
+			for _, field := range []string{"a", "b", "c"} {
+				wg.Add(1)
+				go func(field string) {
+					defer wg.Done()
+					if err := s.SetFields(sess.ID, map[string]interface{}{field: true}); err != nil {
+						t.Errorf("Error setting %s: %s", field, err)
+					}
+				}(field)
+			}
+			wg.Wait()
+
+			loaded, err := s.Load(sess.ID)
+			if err != nil {
+				t.Fatalf("Error loading the session: %s", err)
+			}
+
+			for _, field := range []string{"user", "a", "b", "c"} {
+				if _, ok := loaded.Values[field]; !ok {
+					t.Errorf("Expected %s to be kept, got %v", field, loaded.Values)
+				}
+			}
+
+			if err := s.SetFields("missing", map[string]interface{}{"a": 1}); err != ErrNoSession {
+				t.Errorf("Expected setting fields of a missing session to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_SlidingExpiration(t *testing.T) {
+	for name, c := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := NewStore(c, Opts{MaxAge: 300 * time.Millisecond})
+			sess, err := s.Create(nil)
+			if err != nil {
+				t.Fatalf("Error creating a session: %s", err)
+			}
+
+			for i := 0; i < 3; i++ {
+				time.Sleep(200 * time.Millisecond)
+				if _, err := s.Load(sess.ID); err != nil {
+					t.Fatalf("Expected a used session to live on, got %v", err)
+				}
+			}
+
+			time.Sleep(500 * time.Millisecond)
+			if _, err := s.Load(sess.ID); err != ErrNoSession {
+				t.Errorf("Expected an idle session to expire, got %v", err)
+			}
+		})
+	}
+}
+
+// writeCounter counts the writes made to an InMemoryCache.
+type writeCounter struct {
+	cache.InMemoryCache
+	writes int
+}
+
+func (c *writeCounter) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	c.writes++
+	return c.InMemoryCache.SetFields(key, value, expires)
+}
+
+func TestStore_LoadTouches(t *testing.T) {
+	c := &writeCounter{InMemoryCache: cache.NewInMemoryCache(time.Hour)}
+	s := NewStore(c, Opts{})
+	sess, err := s.Create(nil)
+	if err != nil {
+		t.Fatalf("Error creating a session: %s", err)
+	}
+
+	if _, err := s.Load(sess.ID); err != nil {
+		t.Fatalf("Error loading the session: %s", err)
+	}
+
+	if c.writes != 0 {
+		t.Errorf("Expected loading to touch the session rather than write it, got %d writes", c.writes)
+	}
+}
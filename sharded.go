@@ -0,0 +1,302 @@
+package cache
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultVirtualNodes = 160
+
+// Shard is one of the backends of a ShardedCache. Its name places it on the
+// hash ring, so it must stay the same across restarts for keys to keep going
+// to the same backend.
+type Shard struct {
+	Name  string
+	Cache Cache
+}
+
+// ShardedCache spreads keys over several backends, typically standalone
+// Redis servers, with a consistent hash ring. Each shard is placed on the
+// ring many times, so keys are spread evenly and adding or removing a shard
+// only moves the keys it gains or loses.
+//
+// Keys and Flush fan out to every shard. GetMulti makes one call per shard
+// holding some of the keys.
+type ShardedCache struct {
+	mu           sync.RWMutex
+	virtualNodes int
+	shards       map[string]Cache
+	ring         []uint32          // Sorted hashes of the virtual nodes.
+	owners       map[uint32]string // Shard of each virtual node.
+}
+
+// NewShardedCache returns a ShardedCache over shards, placing each of them on
+// the ring virtualNodes times. Zero uses a default suited to a handful of
+// shards.
+func NewShardedCache(virtualNodes int, shards ...Shard) *ShardedCache {
+	if virtualNodes == 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	c := &ShardedCache{
+		virtualNodes: virtualNodes,
+		shards:       map[string]Cache{},
+	}
+
+	for _, shard := range shards {
+		c.shards[shard.Name] = shard.Cache
+	}
+	c.build()
+	return c
+}
+
+// build places the shards on the ring. The caller holds c.mu for writing.
+func (c *ShardedCache) build() {
+	c.ring = c.ring[:0]
+	c.owners = make(map[uint32]string, len(c.shards)*c.virtualNodes)
+	for name := range c.shards {
+		for i := 0; i < c.virtualNodes; i++ {
+			// The separator keeps "x"+"10" apart from "x1"+"0".
+			h := crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(i)))
+			if owner, ok := c.owners[h]; ok && owner < name {
+				// Settle collisions the same way whatever the order.
+				continue
+			}
+
+			if _, ok := c.owners[h]; !ok {
+				c.ring = append(c.ring, h)
+			}
+			c.owners[h] = name
+		}
+	}
+
+	sort.Slice(c.ring, func(i, j int) bool { return c.ring[i] < c.ring[j] })
+}
+
+// AddShard adds shard to the ring, replacing any shard of the same name. It
+// takes over the keys now hashed to it, which other shards still hold until
+// they expire.
+func (c *ShardedCache) AddShard(shard Shard) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.shards[shard.Name] = shard.Cache
+	c.build()
+}
+
+// RemoveShard removes the shard called name from the ring, its keys moving to
+// the remaining shards.
+func (c *ShardedCache) RemoveShard(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.shards, name)
+	c.build()
+}
+
+// shardName returns the name of the shard owning key. The caller holds c.mu.
+func (c *ShardedCache) shardName(key string) string {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i] >= h })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.owners[c.ring[i]]
+}
+
+// shard returns the backend owning key.
+func (c *ShardedCache) shard(key string) (Cache, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.ring) == 0 {
+		return nil, ErrNoShards
+	}
+	return c.shards[c.shardName(key)], nil
+}
+
+// all returns every backend.
+func (c *ShardedCache) all() []Cache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	caches := make([]Cache, 0, len(c.shards))
+	for _, cache := range c.shards {
+		caches = append(caches, cache)
+	}
+	return caches
+}
+
+// shardGroup are the keys of a call owned by one shard.
+type shardGroup struct {
+	shard Cache
+	keys  []string
+}
+
+// group splits keys by the shard owning them.
+func (c *ShardedCache) group(keys []string) (map[string]*shardGroup, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.ring) == 0 {
+		return nil, ErrNoShards
+	}
+
+	groups := map[string]*shardGroup{}
+	for _, key := range keys {
+		name := c.shardName(key)
+		if groups[name] == nil {
+			groups[name] = &shardGroup{shard: c.shards[name]}
+		}
+		groups[name].keys = append(groups[name].keys, key)
+	}
+	return groups, nil
+}
+
+func (c *ShardedCache) Get(key string, ptrValue interface{}) error {
+	shard, err := c.shard(key)
+	if err != nil {
+		return err
+	}
+	return shard.Get(key, ptrValue)
+}
+
+func (c *ShardedCache) GetMulti(keys ...string) (Getter, error) {
+	groups, err := c.group(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	g := shardedGetter{}
+	for _, group := range groups {
+		sg, err := group.shard.GetMulti(group.keys...)
+		if err == ErrCacheMiss {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range group.keys {
+			g[key] = sg
+		}
+	}
+
+	return g, nil
+}
+
+// SetMulti sets every item of items, setting those of different shards
+// concurrently. It returns the first error.
+func (c *ShardedCache) SetMulti(items map[string]interface{}, expires time.Duration) error {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+
+	groups, err := c.group(keys)
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(groups))
+	for _, group := range groups {
+		go func(group *shardGroup) {
+			for _, key := range group.keys {
+				if err := group.shard.Set(key, items[key], expires); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(group)
+	}
+
+	var firstErr error
+	for range groups {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *ShardedCache) Set(key string, value interface{}, expires time.Duration) error {
+	shard, err := c.shard(key)
+	if err != nil {
+		return err
+	}
+	return shard.Set(key, value, expires)
+}
+
+func (c *ShardedCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	shard, err := c.shard(key)
+	if err != nil {
+		return err
+	}
+	return shard.SetFields(key, value, expires)
+}
+
+func (c *ShardedCache) Add(key string, value interface{}, expires time.Duration) error {
+	shard, err := c.shard(key)
+	if err != nil {
+		return err
+	}
+	return shard.Add(key, value, expires)
+}
+
+func (c *ShardedCache) Replace(key string, value interface{}, expires time.Duration) error {
+	shard, err := c.shard(key)
+	if err != nil {
+		return err
+	}
+	return shard.Replace(key, value, expires)
+}
+
+func (c *ShardedCache) Delete(key string) error {
+	shard, err := c.shard(key)
+	if err != nil {
+		return err
+	}
+	return shard.Delete(key)
+}
+
+// Flush flushes every shard, returning the first error.
+func (c *ShardedCache) Flush() error {
+	var firstErr error
+	for _, shard := range c.all() {
+		if err := shard.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Keys returns the keys of every shard.
+func (c *ShardedCache) Keys() ([]string, error) {
+	var keys []string
+	for _, shard := range c.all() {
+		shardKeys, err := shard.Keys()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, shardKeys...)
+	}
+
+	return keys, nil
+}
+
+// shardedGetter implements a Getter on top of the getters returned by the
+// shards, by key.
+type shardedGetter map[string]Getter
+
+func (g shardedGetter) Get(key string, ptrValue interface{}) error {
+	sg, ok := g[key]
+	if !ok {
+		return ErrCacheMiss
+	}
+	return sg.Get(key, ptrValue)
+}
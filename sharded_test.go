@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestShards(n int, defaultExpiration time.Duration) []Shard {
+	var shards []Shard
+	for i := 0; i < n; i++ {
+		shards = append(shards, Shard{
+			Name:  fmt.Sprintf("redis-%d", i),
+			Cache: NewInMemoryCacheWithOpts(InMemoryOpts{Expiration: defaultExpiration, Clock: newFakeClock()}),
+		})
+	}
+	return shards
+}
+
+var newShardedCache = func(_ *testing.T, defaultExpiration time.Duration) Cache {
+	return NewShardedCache(0, newTestShards(3, defaultExpiration)...)
+}
+
+func TestShardedCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newShardedCache)
+}
+
+func TestShardedCache_SetFields(t *testing.T) {
+	testSetFields(t, newShardedCache)
+}
+
+func TestShardedCache_Expiration(t *testing.T) {
+	expiration(t, newShardedCache)
+}
+
+func TestShardedCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newShardedCache)
+}
+
+func TestShardedCache_Replace(t *testing.T) {
+	testReplace(t, newShardedCache)
+}
+
+func TestShardedCache_Add(t *testing.T) {
+	testAdd(t, newShardedCache)
+}
+
+func TestShardedCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newShardedCache)
+}
+
+func TestShardedCache_Keys(t *testing.T) {
+	testKeys(t, newShardedCache)
+}
+
+func TestShardedCache_Spread(t *testing.T) {
+	shards := newTestShards(4, time.Hour)
+	c := NewShardedCache(0, shards...)
+
+	items := map[string]interface{}{}
+	for i := 0; i < 4000; i++ {
+		items[fmt.Sprintf("key%d", i)] = i
+	}
+
+	if err := c.SetMulti(items, time.Hour); err != nil {
+		t.Fatalf("Error in set-multi: %s", err)
+	}
+
+	for _, shard := range shards {
+		keys, _ := shard.Cache.Keys()
+		if len(keys) < 500 || len(keys) > 1500 {
+			t.Errorf("Expected about 1000 keys on %s, got %d", shard.Name, len(keys))
+		}
+	}
+
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+
+	g, err := c.GetMulti(keys...)
+	if err != nil {
+		t.Fatalf("Error in get-multi: %s", err)
+	}
+
+	for key, expected := range items {
+		var value int
+		if err := g.Get(key, &value); err != nil || value != expected {
+			t.Errorf("Error getting %s: %s / %d", key, err, value)
+		}
+	}
+}
+
+func TestShardedCache_Rebalance(t *testing.T) {
+	c := NewShardedCache(0, newTestShards(4, time.Hour)...)
+
+	owners := map[string]string{}
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owners[key] = c.shardName(key)
+	}
+
+	// Only the keys taken over by the new shard move.
+	c.AddShard(Shard{Name: "redis-4", Cache: NewInMemoryCache(time.Hour)})
+	moved := 0
+	for key, owner := range owners {
+		name := c.shardName(key)
+		if name != owner {
+			moved++
+			if name != "redis-4" {
+				t.Fatalf("Expected %s to stay on %s or move to redis-4, got %s", key, owner, name)
+			}
+		}
+	}
+
+	if moved < 400 || moved > 1200 {
+		t.Errorf("Expected about 800 keys to move, got %d", moved)
+	}
+
+	// Removing it moves them back.
+	c.RemoveShard("redis-4")
+	for key, owner := range owners {
+		if name := c.shardName(key); name != owner {
+			t.Errorf("Expected %s back on %s, got %s", key, owner, name)
+		}
+	}
+
+	c.RemoveShard("redis-0")
+	for key, owner := range owners {
+		if name := c.shardName(key); owner != "redis-0" && name != owner {
+			t.Errorf("Expected %s to stay on %s, got %s", key, owner, name)
+		}
+	}
+}
+
+func TestShardedCache_NoShards(t *testing.T) {
+	c := NewShardedCache(0)
+	if err := c.Set("key", 1, time.Hour); err != ErrNoShards {
+		t.Errorf("Expected ErrNoShards, got: %v", err)
+	}
+}
+
+func TestShardedCache_VirtualNodeLabels(t *testing.T) {
+	c := NewShardedCache(20,
+		Shard{Name: "x", Cache: NewInMemoryCache(time.Hour)},
+		Shard{Name: "0x", Cache: NewInMemoryCache(time.Hour)},
+	)
+
+	// Without a separator, node 10 of "x" and node 1 of "0x" would share a label.
+	if len(c.ring) != 40 {
+		t.Errorf("Expected 40 distinct virtual nodes, got %d", len(c.ring))
+	}
+}
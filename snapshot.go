@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// snapshotItem is how an item of an InMemoryCache is written by Save, one
+// JSON document per item.
+type snapshotItem struct {
+	Key      string          `json:"key"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	Negative bool            `json:"negative,omitempty"`
+	// ExpiresAt is when the item expires, in Unix nanoseconds. Zero means
+	// never.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// Save writes every live item of the cache to w, along with when it expires.
+// Locks are left out: loaded into another process, nobody could release them.
+func (c InMemoryCache) Save(w io.Writer) error {
+	items := func() map[string]cache.Item {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return c.cache.Items()
+	}()
+
+	now := c.clock.Now()
+	enc := json.NewEncoder(w)
+	for key, v := range items {
+		item := v.Object.(inMemoryItem)
+		if item.expired(now) || isLockKey(key) {
+			continue
+		}
+
+		s := snapshotItem{Key: key, ExpiresAt: item.expiration}
+		if _, ok := item.value.(tombstone); ok {
+			s.Negative = true
+		} else {
+			b, err := unpack(item.value)
+			if err != nil {
+				return err
+			}
+			s.Value = b
+		}
+
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load reads items written by Save from r into the cache, with the TTL they
+// have left. Items that have expired since are skipped.
+func (c InMemoryCache) Load(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var s snapshotItem
+		if err := dec.Decode(&s); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		expires := ForEverNeverExpiry
+		if s.ExpiresAt != 0 {
+			expires = time.Unix(0, s.ExpiresAt).Sub(c.clock.Now())
+			if expires <= 0 {
+				continue
+			}
+		}
+
+		var err error
+		if s.Negative {
+			err = c.SetNegative(s.Key, expires)
+		} else {
+			err = c.Set(s.Key, s.Value, expires)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// SnapshotToFile warms the cache from the snapshot at path, if there is one,
+// and then saves a snapshot to path every interval. Calling the returned stop
+// function ends the periodic snapshots, saving one last time.
+//
+// Snapshots are written next to path and renamed over it, so path always holds
+// a complete snapshot.
+func (c InMemoryCache) SnapshotToFile(path string, interval time.Duration) (stop func() error, err error) {
+	f, err := os.Open(path)
+	if err == nil {
+		err = c.Load(f)
+		f.Close()
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if err := c.saveFile(path); err != nil {
+					c.logger.Error("cache: cannot save snapshot", "path", path, "error", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	var stopErr error
+	stop = func() error {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			stopErr = c.saveFile(path)
+		})
+		return stopErr
+	}
+	return stop, nil
+}
+
+func (c InMemoryCache) saveFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Save(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInMemoryCache_SaveLoad(t *testing.T) {
+	clock := newFakeClock()
+	c := NewInMemoryCacheWithOpts(InMemoryOpts{
+		Expiration:           time.Hour,
+		Compression:          GzipCompression,
+		CompressionThreshold: 1,
+		Clock:                clock,
+	})
+
+	c.Set("forever", "foo", ForEverNeverExpiry)
+	c.Set("hash", map[string]interface{}{"field": "bar"}, time.Hour)
+	c.Set("short", 42, time.Second)
+	c.Set("expired", 1, testExpiryTime)
+	c.SetNegative("missing", time.Hour)
+	if _, err := c.Lock(context.Background(), "locked", time.Hour); err != nil {
+		t.Fatalf("Error locking: %s", err)
+	}
+	clock.Advance(10 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatalf("Error saving: %s", err)
+	}
+
+	restored := NewInMemoryCacheWithOpts(InMemoryOpts{Expiration: time.Hour, Clock: clock})
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("Error loading: %s", err)
+	}
+
+	var str string
+	if err := restored.Get("forever", &str); err != nil || str != "foo" {
+		t.Errorf("Error getting forever: %s / %s", err, str)
+	}
+
+	var hash map[string]string
+	if err := restored.Get("hash", &hash); err != nil || hash["field"] != "bar" {
+		t.Errorf("Error getting hash: %s / %v", err, hash)
+	}
+
+	var num int
+	if err := restored.Get("short", &num); err != nil || num != 42 {
+		t.Errorf("Error getting short: %s / %d", err, num)
+	}
+
+	if err := restored.Get("expired", &num); err != ErrCacheMiss {
+		t.Errorf("Expected expired items to be skipped, got: %v", err)
+	}
+
+	if err := restored.Get("missing", &num); err != ErrNegativeHit {
+		t.Errorf("Expected tombstones to be restored, got: %v", err)
+	}
+
+	// Locks are not restored.
+	lock, err := restored.Lock(context.Background(), "locked", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected locks to be skipped, got: %v", err)
+	}
+	lock.Unlock(context.Background())
+
+	// Items keep the TTL they had left.
+	clock.Advance(time.Second)
+	if err := restored.Get("short", &num); err != ErrCacheMiss {
+		t.Errorf("Expected short to expire, got: %v", err)
+	}
+}
+
+func TestInMemoryCache_SnapshotToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+
+	c := NewInMemoryCache(time.Hour)
+	stop, err := c.SnapshotToFile(path, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error starting snapshots: %s", err)
+	}
+
+	c.Set("value", "foo", time.Hour)
+
+	// Periodic snapshots happen while running.
+	if !eventually(func() bool { _, err := os.Stat(path); return err == nil }) {
+		t.Errorf("Expected a snapshot")
+	}
+
+	c.Set("last", "bar", time.Hour)
+	if err := stop(); err != nil {
+		t.Fatalf("Error stopping snapshots: %s", err)
+	}
+
+	// A restarting process warms from the last one.
+	warm := NewInMemoryCache(time.Hour)
+	stop, err = warm.SnapshotToFile(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Error starting snapshots: %s", err)
+	}
+	defer stop()
+
+	for key, expected := range map[string]string{"value": "foo", "last": "bar"} {
+		var value string
+		if err := warm.Get(key, &value); err != nil || value != expected {
+			t.Errorf("Error getting %s: %s / %s", key, err, value)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package cache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQLOpts configures a SQLCache.
+type SQLOpts struct {
+	// Table holding the items, created if missing. Defaults to "cache". It
+	// must be a plain identifier: letters, digits and underscores.
+	Table      string
+	Expiration time.Duration
+	// CleanupInterval is how often expired rows are deleted. A negative
+	// interval disables the cleanup, leaving it to calls to Cleanup.
+	CleanupInterval time.Duration
+	// Logger receives the errors of the periodic cleanup.
+	Logger Logger
+	// Clock decides when items expire. Defaults to the system clock.
+	Clock Clock
+}
+
+const (
+	defaultSQLTable        = "cache"
+	defaultCleanupInterval = time.Minute
+)
+
+// SQLite allows at most 999 parameters in a statement.
+const sqlMaxBatch = 500
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (o SQLOpts) padDefaults() SQLOpts {
+	if o.Table == "" {
+		o.Table = defaultSQLTable
+	}
+
+	if o.CleanupInterval == 0 {
+		o.CleanupInterval = defaultCleanupInterval
+	}
+
+	if o.Clock == nil {
+		o.Clock = SystemClock{}
+	}
+
+	o.Logger = loggerOrNop(o.Logger)
+	return o
+}
+
+// SQLCache is a Cache on top of a table of a SQL database, with one row per
+// item holding its key, JSON encoded value, and expiration time in Unix
+// nanoseconds (zero for never).
+//
+// Queries are written for SQLite, and work on databases sharing its dialect.
+//
+// SetFields is atomic between users of the same SQLCache. Between processes
+// sharing a SQLite database, open it with _txlock=immediate so that
+// concurrent calls wait for each other rather than fail with SQLITE_BUSY.
+type SQLCache struct {
+	mu                sync.Mutex // For SetFields serialize read and write
+	db                *sql.DB
+	table             string // Quoted.
+	defaultExpiration time.Duration
+	logger            Logger
+	clock             Clock
+	events            eventHandlers
+	done              chan struct{}
+	wg                sync.WaitGroup
+	closeOnce         sync.Once
+}
+
+// NewSQLCache returns a SQLCache storing its items in db, and starts deleting
+// expired rows periodically.
+//
+// Returns ErrInvalidTable if opts.Table is not a plain identifier.
+func NewSQLCache(db *sql.DB, opts SQLOpts) (*SQLCache, error) {
+	opts = opts.padDefaults()
+	if !sqlIdentifier.MatchString(opts.Table) {
+		return nil, ErrInvalidTable
+	}
+
+	c := &SQLCache{
+		db:                db,
+		table:             `"` + opts.Table + `"`,
+		defaultExpiration: opts.Expiration,
+		logger:            opts.Logger,
+		clock:             opts.Clock,
+		done:              make(chan struct{}),
+	}
+
+	if err := c.createTable(opts.Table); err != nil {
+		return nil, err
+	}
+
+	if opts.CleanupInterval > 0 {
+		c.wg.Add(1)
+		go c.cleanup(opts.CleanupInterval)
+	}
+	return c, nil
+}
+
+func (c *SQLCache) createTable(name string) error {
+	_, err := c.db.Exec(`CREATE TABLE IF NOT EXISTS ` + c.table + ` (
+		key TEXT PRIMARY KEY,
+		value BLOB NOT NULL,
+		expires_at INTEGER NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(`CREATE INDEX IF NOT EXISTS "` + name + `_expires_at" ON ` + c.table + ` (expires_at)`)
+	return err
+}
+
+func (c *SQLCache) cleanup(interval time.Duration) {
+	defer c.wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if err := c.Cleanup(); err != nil {
+				c.logger.Error("cache: cleanup failed", "table", c.table, "error", err)
+			}
+		}
+	}
+}
+
+// Close stops the periodic cleanup. The database is left open.
+func (c *SQLCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+	})
+	return nil
+}
+
+// OnEvent implements EventSource. Expired keys are reported once the cleanup
+// deletes them. Items are never evicted.
+func (c *SQLCache) OnEvent(fn func(Event)) {
+	c.events.add(fn)
+}
+
+// Cleanup deletes the expired rows.
+func (c *SQLCache) Cleanup() error {
+	now := c.clock.Now().UnixNano()
+	if !c.events.active() {
+		_, err := c.db.Exec(`DELETE FROM `+c.table+` WHERE expires_at != 0 AND expires_at <= ?`, now)
+		return err
+	}
+
+	keys, err := c.deleteExpired(now)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		c.events.emit(EventExpire, key)
+	}
+	return nil
+}
+
+// deleteExpired deletes the rows expired at now, and returns their keys.
+func (c *SQLCache) deleteExpired(now int64) ([]string, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT key FROM `+c.table+` WHERE expires_at != 0 AND expires_at <= ?`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM `+c.table+` WHERE expires_at != 0 AND expires_at <= ?`, now); err != nil {
+		return nil, err
+	}
+	return keys, tx.Commit()
+}
+
+// expiresAt returns the expiration time of an item stored now for expires.
+func (c *SQLCache) expiresAt(expires time.Duration) int64 {
+	if expires == DefaultExpiryTime {
+		expires = c.defaultExpiration
+	}
+
+	if expires <= 0 {
+		return 0
+	}
+	return c.clock.Now().Add(expires).UnixNano()
+}
+
+// live is the condition matching rows which have not expired.
+const sqlLive = `(expires_at = 0 OR expires_at > ?)`
+
+// sqlQueryer is implemented by both *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (c *SQLCache) read(q sqlQueryer, key string) ([]byte, error) {
+	var b []byte
+	err := q.QueryRow(`SELECT value FROM `+c.table+` WHERE key = ? AND `+sqlLive, key, c.clock.Now().UnixNano()).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, ErrCacheMiss
+	}
+	return b, err
+}
+
+func (c *SQLCache) Get(key string, ptrValue interface{}) error {
+	b, err := c.read(c.db, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, ptrValue)
+}
+
+// GetMulti fetches all keys with one query per batch of keys.
+func (c *SQLCache) GetMulti(keys ...string) (Getter, error) {
+	m := make(SQLItemMapGetter, len(keys))
+	now := c.clock.Now().UnixNano()
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > sqlMaxBatch {
+			batch = batch[:sqlMaxBatch]
+		}
+		keys = keys[len(batch):]
+
+		args := make([]interface{}, 0, len(batch)+1)
+		for _, key := range batch {
+			args = append(args, key)
+		}
+		args = append(args, now)
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := c.db.Query(`SELECT key, value FROM `+c.table+` WHERE key IN (`+placeholders+`) AND `+sqlLive, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var key string
+			var b []byte
+			if err := rows.Scan(&key, &b); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			m[key] = b
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (c *SQLCache) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.db.Exec(`REPLACE INTO `+c.table+` (key, value, expires_at) VALUES (?, ?, ?)`, key, b, c.expiresAt(expires)); err != nil {
+		return err
+	}
+
+	c.events.emit(EventSet, key)
+	return nil
+}
+
+func (c *SQLCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	// In a deferred transaction, concurrent calls would all read, and all but
+	// one fail with SQLITE_BUSY when upgrading to write.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b, err := c.read(tx, key)
+	if err == ErrCacheMiss {
+		return ErrNotStored
+	}
+
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]interface{}{}
+	if err := json.Unmarshal(b, &existing); err != nil {
+		return err
+	}
+
+	for k, v := range value {
+		existing[k] = v
+	}
+
+	if b, err = json.Marshal(existing); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE `+c.table+` SET value = ?, expires_at = ? WHERE key = ?`, b, c.expiresAt(expires), key); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	c.events.emit(EventSet, key)
+	return nil
+}
+
+func (c *SQLCache) Add(key string, value interface{}, expires time.Duration) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// An expired row still holds the key until the cleanup deletes it.
+	now := c.clock.Now().UnixNano()
+	if _, err := tx.Exec(`DELETE FROM `+c.table+` WHERE key = ? AND NOT `+sqlLive, key, now); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`INSERT INTO `+c.table+` (key, value, expires_at) SELECT ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM `+c.table+` WHERE key = ?)`, key, b, c.expiresAt(expires), key)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotStored
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	c.events.emit(EventSet, key)
+	return nil
+}
+
+func (c *SQLCache) Replace(key string, value interface{}, expires time.Duration) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.db.Exec(`UPDATE `+c.table+` SET value = ?, expires_at = ? WHERE key = ? AND `+sqlLive, b, c.expiresAt(expires), key, c.clock.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotStored
+	}
+
+	c.events.emit(EventSet, key)
+	return nil
+}
+
+func (c *SQLCache) Delete(key string) error {
+	res, err := c.db.Exec(`DELETE FROM `+c.table+` WHERE key = ?`, key)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
+		c.events.emit(EventDelete, key)
+	}
+	return nil
+}
+
+func (c *SQLCache) Flush() error {
+	if _, err := c.db.Exec(`DELETE FROM ` + c.table); err != nil {
+		return err
+	}
+
+	c.events.emit(EventFlush, "")
+	return nil
+}
+
+func (c *SQLCache) Keys() ([]string, error) {
+	rows, err := c.db.Query(`SELECT key FROM `+c.table+` WHERE `+sqlLive, c.clock.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// SQLItemMapGetter implements a Getter on top of the returned item map.
+type SQLItemMapGetter map[string][]byte
+
+func (g SQLItemMapGetter) Get(key string, ptrValue interface{}) error {
+	b, ok := g[key]
+	if !ok {
+		return ErrCacheMiss
+	}
+	return json.Unmarshal(b, ptrValue)
+}
@@ -0,0 +1,210 @@
+//go:build cgo
+
+// The SQL tests run on go-sqlite3, which needs cgo.
+
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestSQLCache(t *testing.T, opts SQLOpts) *SQLCache {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening a database: %s", err)
+	}
+
+	// Every connection has its own in-memory database.
+	db.SetMaxOpenConns(1)
+
+	c, err := NewSQLCache(db, opts)
+	if err != nil {
+		t.Fatalf("Error creating a sql cache: %s", err)
+	}
+	return c
+}
+
+var newSQLCache = func(t *testing.T, defaultExpiration time.Duration) Cache {
+	return newTestSQLCache(t, SQLOpts{Expiration: defaultExpiration, Clock: newFakeClock()})
+}
+
+func TestSQLCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newSQLCache)
+}
+
+func TestSQLCache_SetFields(t *testing.T) {
+	testSetFields(t, newSQLCache)
+}
+
+func TestSQLCache_Expiration(t *testing.T) {
+	expiration(t, newSQLCache)
+}
+
+func TestSQLCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newSQLCache)
+}
+
+func TestSQLCache_Replace(t *testing.T) {
+	testReplace(t, newSQLCache)
+}
+
+func TestSQLCache_Add(t *testing.T) {
+	testAdd(t, newSQLCache)
+}
+
+func TestSQLCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newSQLCache)
+}
+
+func TestSQLCache_Keys(t *testing.T) {
+	testKeys(t, newSQLCache)
+}
+
+func TestSQLCache_GetMultiBatches(t *testing.T) {
+	c := newTestSQLCache(t, SQLOpts{Expiration: time.Hour})
+	defer c.Close()
+
+	var keys []string
+	for i := 0; i < 2*sqlMaxBatch+1; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		c.Set(key, i, time.Hour)
+	}
+
+	g, err := c.GetMulti(append(keys, "missing")...)
+	if err != nil {
+		t.Fatalf("Error in get-multi: %s", err)
+	}
+
+	for i, key := range keys {
+		var value int
+		if err := g.Get(key, &value); err != nil || value != i {
+			t.Errorf("Error getting %s: %s / %d", key, err, value)
+		}
+	}
+
+	var value int
+	if err := g.Get("missing", &value); err != ErrCacheMiss {
+		t.Errorf("Expected missing to be a miss, got: %v", err)
+	}
+}
+
+func TestSQLCache_Cleanup(t *testing.T) {
+	clock := newFakeClock()
+	c := newTestSQLCache(t, SQLOpts{
+		Expiration:      time.Hour,
+		CleanupInterval: 10 * time.Millisecond,
+		Clock:           clock,
+	})
+	defer c.Close()
+
+	c.Set("short", 1, time.Second)
+	c.Set("long", 2, time.Hour)
+	clock.Advance(2 * time.Second)
+
+	var n int
+	deleted := eventually(func() bool {
+		if err := c.db.QueryRow(`SELECT COUNT(*) FROM cache`).Scan(&n); err != nil {
+			t.Fatalf("Error counting rows: %s", err)
+		}
+		return n == 1
+	})
+
+	if !deleted {
+		t.Errorf("Expected the expired row to be deleted, got %d rows", n)
+	}
+}
+
+func TestSQLCache_Events(t *testing.T) {
+	c := newTestSQLCache(t, SQLOpts{CleanupInterval: -1})
+	defer c.Close()
+
+	testEvents(t, c)
+}
+
+func TestSQLCache_ExpireEvents(t *testing.T) {
+	c := newTestSQLCache(t, SQLOpts{CleanupInterval: -1})
+	defer c.Close()
+
+	c.Set("short", 1, time.Millisecond)
+	c.Set("long", 2, time.Hour)
+	time.Sleep(5 * time.Millisecond)
+
+	r := &eventRecorder{}
+	c.OnEvent(r.record)
+	if err := c.Cleanup(); err != nil {
+		t.Fatalf("Error cleaning up: %s", err)
+	}
+
+	expectEvents(t, r, Event{Type: EventExpire, Key: "short"})
+}
+
+func TestSQLCache_Table(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening a database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, table := range []string{"cache; DROP TABLE users", `a"b`, "1cache"} {
+		if _, err := NewSQLCache(db, SQLOpts{Table: table}); err != ErrInvalidTable {
+			t.Errorf("Expected ErrInvalidTable for %q, got: %v", table, err)
+		}
+	}
+
+	// Quoted, so that keywords can name the table.
+	c, err := NewSQLCache(db, SQLOpts{Table: "order"})
+	if err != nil {
+		t.Fatalf("Error creating a sql cache: %s", err)
+	}
+
+	if err := c.Set("a", 1, time.Hour); err != nil {
+		t.Errorf("Error setting a value: %s", err)
+	}
+
+	c.Close()
+	c.Close()
+}
+
+func TestSQLCache_ConcurrentSetFields(t *testing.T) {
+	// A file, so that every connection sees the same database.
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("Error opening a database: %s", err)
+	}
+	defer db.Close()
+
+	c, err := NewSQLCache(db, SQLOpts{CleanupInterval: -1})
+	if err != nil {
+		t.Fatalf("Error creating a sql cache: %s", err)
+	}
+	defer c.Close()
+
+	c.Set("hash", map[string]interface{}{}, time.Hour)
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := c.SetFields("hash", map[string]interface{}{fmt.Sprint(i): i}, time.Hour); err != nil {
+				t.Errorf("Error setting fields: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var hash map[string]interface{}
+	if err := c.Get("hash", &hash); err != nil || len(hash) != n {
+		t.Errorf("Expected every field to be kept: %v / %v", err, hash)
+	}
+}
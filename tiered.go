@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TieredCache is a near cache: reads are served from a local InMemoryCache
+// and fall through to a remote Cache, usually a RedisCache, keeping a short
+// lived local copy of whatever was read.
+//
+// Writes go through to both tiers, so a process always sees its own writes.
+// Writes made by other processes are only seen once the local copy expires,
+// unless the processes share an InvalidationBus.
+type TieredCache struct {
+	local           InMemoryCache
+	remote          Cache
+	localExpiration time.Duration
+	bus             InvalidationBus
+	id              string
+}
+
+// NewTieredCache returns a TieredCache keeping local copies of remote values
+// for at most localExpiration.
+func NewTieredCache(local InMemoryCache, remote Cache, localExpiration time.Duration) *TieredCache {
+	return &TieredCache{
+		local:           local,
+		remote:          remote,
+		localExpiration: localExpiration,
+	}
+}
+
+// UseInvalidationBus publishes every write made through c on bus, and drops
+// the local copies of keys written by others on the bus. It must be called
+// before c is used.
+func (c *TieredCache) UseInvalidationBus(bus InvalidationBus) error {
+	id, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	c.bus, c.id = bus, id
+	bus.Subscribe(c.invalidate)
+	return nil
+}
+
+func (c *TieredCache) invalidate(inv Invalidation) {
+	if inv.Source == c.id {
+		return
+	}
+
+	if inv.Flush {
+		c.local.Flush()
+		return
+	}
+
+	for _, key := range inv.Keys {
+		c.local.Delete(key)
+	}
+}
+
+// publish tells the other users of the bus to drop what was just written.
+func (c *TieredCache) publish(inv Invalidation) error {
+	if c.bus == nil {
+		return nil
+	}
+
+	inv.Source = c.id
+	return c.bus.Publish(inv)
+}
+
+// localExpiry caps expires to the lifetime of a local copy.
+func (c *TieredCache) localExpiry(expires time.Duration) time.Duration {
+	if expires > 0 && expires < c.localExpiration {
+		return expires
+	}
+	return c.localExpiration
+}
+
+// keepLocal stores a copy of value in the local tier. The value is kept
+// encoded so that later changes made by the caller do not leak into it.
+func (c *TieredCache) keepLocal(key string, value interface{}, expires time.Duration) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.local.Set(key, json.RawMessage(b), c.localExpiry(expires))
+}
+
+func (c *TieredCache) Get(key string, ptrValue interface{}) error {
+	if err := c.local.Get(key, ptrValue); err != ErrCacheMiss {
+		return err
+	}
+
+	if err := c.remote.Get(key, ptrValue); err != nil {
+		return err
+	}
+	return c.keepLocal(key, ptrValue, c.localExpiration)
+}
+
+func (c *TieredCache) GetMulti(keys ...string) (Getter, error) {
+	var missing []string
+	for _, key := range keys {
+		var v json.RawMessage
+		if err := c.local.Get(key, &v); err == ErrCacheMiss {
+			missing = append(missing, key)
+		}
+	}
+
+	g := tieredGetter{c: c}
+	if len(missing) == 0 {
+		return g, nil
+	}
+
+	remote, err := c.remote.GetMulti(missing...)
+	if err != nil {
+		return nil, err
+	}
+
+	g.remote = remote
+	return g, nil
+}
+
+func (c *TieredCache) Set(key string, value interface{}, expires time.Duration) error {
+	if err := c.remote.Set(key, value, expires); err != nil {
+		c.local.Delete(key)
+		return err
+	}
+
+	if err := c.keepLocal(key, value, expires); err != nil {
+		return err
+	}
+	return c.publish(Invalidation{Keys: []string{key}})
+}
+
+func (c *TieredCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	// Only the remote tier knows the merged value, so drop the local copy
+	// and let the next read fetch it.
+	defer c.local.Delete(key)
+	if err := c.remote.SetFields(key, value, expires); err != nil {
+		return err
+	}
+	return c.publish(Invalidation{Keys: []string{key}})
+}
+
+func (c *TieredCache) Add(key string, value interface{}, expires time.Duration) error {
+	if err := c.remote.Add(key, value, expires); err != nil {
+		return err
+	}
+
+	if err := c.keepLocal(key, value, expires); err != nil {
+		return err
+	}
+	return c.publish(Invalidation{Keys: []string{key}})
+}
+
+func (c *TieredCache) Replace(key string, value interface{}, expires time.Duration) error {
+	if err := c.remote.Replace(key, value, expires); err != nil {
+		c.local.Delete(key)
+		return err
+	}
+
+	if err := c.keepLocal(key, value, expires); err != nil {
+		return err
+	}
+	return c.publish(Invalidation{Keys: []string{key}})
+}
+
+func (c *TieredCache) Delete(key string) error {
+	defer c.local.Delete(key)
+	if err := c.remote.Delete(key); err != nil {
+		return err
+	}
+	return c.publish(Invalidation{Keys: []string{key}})
+}
+
+func (c *TieredCache) Flush() error {
+	defer c.local.Flush()
+	if err := c.remote.Flush(); err != nil {
+		return err
+	}
+	return c.publish(Invalidation{Flush: true})
+}
+
+// Keys returns the keys of the remote tier, which holds every key.
+func (c *TieredCache) Keys() ([]string, error) {
+	return c.remote.Keys()
+}
+
+// tieredGetter implements a Getter reading the local tier first and falling
+// through to the values fetched from the remote tier.
+type tieredGetter struct {
+	c      *TieredCache
+	remote Getter
+}
+
+func (g tieredGetter) Get(key string, ptrValue interface{}) error {
+	if err := g.c.local.Get(key, ptrValue); err != ErrCacheMiss {
+		return err
+	}
+
+	if g.remote == nil {
+		return ErrCacheMiss
+	}
+
+	if err := g.remote.Get(key, ptrValue); err != nil {
+		return err
+	}
+	return g.c.keepLocal(key, ptrValue, g.c.localExpiration)
+}
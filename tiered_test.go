@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+var newTieredCache = func(t *testing.T, defaultExpiration time.Duration) Cache {
+	return NewTieredCache(newInMemoryCache(t, defaultExpiration).(InMemoryCache), newInMemoryCache(t, defaultExpiration), time.Minute)
+}
+
+func TestTieredCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newTieredCache)
+}
+
+func TestTieredCache_SetFields(t *testing.T) {
+	testSetFields(t, newTieredCache)
+}
+
+func TestTieredCache_Expiration(t *testing.T) {
+	expiration(t, newTieredCache)
+}
+
+func TestTieredCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newTieredCache)
+}
+
+func TestTieredCache_Replace(t *testing.T) {
+	testReplace(t, newTieredCache)
+}
+
+func TestTieredCache_Add(t *testing.T) {
+	testAdd(t, newTieredCache)
+}
+
+func TestTieredCache_GetMulti(t *testing.T) {
+	testGetMulti(t, newTieredCache)
+}
+
+func TestTieredCache_Keys(t *testing.T) {
+	testKeys(t, newTieredCache)
+}
+
+func TestTieredCache_ReadThrough(t *testing.T) {
+	clock := newFakeClock()
+	local := NewInMemoryCacheWithOpts(InMemoryOpts{Expiration: time.Hour, Clock: clock})
+	remote := NewInMemoryCache(time.Hour)
+	cache := NewTieredCache(local, remote, time.Second)
+
+	if err := remote.Set("value", "foo", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	var value string
+	if err := cache.Get("value", &value); err != nil || value != "foo" {
+		t.Fatalf("Error reading through: %s / %s", err, value)
+	}
+
+	// The local copy must outlive the remote value.
+	if err := remote.Delete("value"); err != nil {
+		t.Fatalf("Error deleting a value: %s", err)
+	}
+
+	value = ""
+	if err := cache.Get("value", &value); err != nil || value != "foo" {
+		t.Errorf("Expected the local copy: %s / %s", err, value)
+	}
+
+	// But only for the local expiration.
+	clock.Advance(2 * time.Second)
+	if err := cache.Get("value", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the local copy to expire, got: %v", err)
+	}
+}
+
+func TestTieredCache_WriteThrough(t *testing.T) {
+	local, remote := NewInMemoryCache(time.Hour), NewInMemoryCache(time.Hour)
+	cache := NewTieredCache(local, remote, time.Minute)
+
+	if err := cache.Set("value", "foo", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	for name, tier := range map[string]Cache{"local": local, "remote": remote} {
+		var value string
+		if err := tier.Get("value", &value); err != nil || value != "foo" {
+			t.Errorf("Expected value in the %s tier: %s / %s", name, err, value)
+		}
+	}
+
+	if err := cache.Delete("value"); err != nil {
+		t.Fatalf("Error deleting a value: %s", err)
+	}
+
+	for name, tier := range map[string]Cache{"local": local, "remote": remote} {
+		var value string
+		if err := tier.Get("value", &value); err != ErrCacheMiss {
+			t.Errorf("Expected value deleted from the %s tier, got: %v", name, err)
+		}
+	}
+}
+
+func TestTieredCache_SetFieldsDropsLocalCopy(t *testing.T) {
+	local, remote := NewInMemoryCache(time.Hour), NewInMemoryCache(time.Hour)
+	cache := NewTieredCache(local, remote, time.Minute)
+
+	if err := cache.Set("value", map[string]interface{}{"field": "foo"}, time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	if err := cache.SetFields("value", map[string]interface{}{"field2": "bar"}, time.Hour); err != nil {
+		t.Fatalf("Error setting fields: %s", err)
+	}
+
+	var value map[string]string
+	if err := cache.Get("value", &value); err != nil {
+		t.Fatalf("Error getting a value: %s", err)
+	}
+
+	if value["field"] != "foo" || value["field2"] != "bar" {
+		t.Errorf("Expected merged fields, got %v", value)
+	}
+}
@@ -0,0 +1,261 @@
+// Package tracing traces the operations of a cache.Cache with OpenTelemetry.
+//
+// It lives apart from the cache package so that users who do not trace do not
+// pull in OpenTelemetry.
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/oogway/go-cache/tracing"
+
+// Attributes set on the spans.
+const (
+	BackendKey   = attribute.Key("cache.backend")
+	KeyKey       = attribute.Key("cache.key")
+	KeysKey      = attribute.Key("cache.keys")
+	HitKey       = attribute.Key("cache.hit")
+	ValueSizeKey = attribute.Key("cache.value_size")
+)
+
+// Opts configures a Cache.
+type Opts struct {
+	// Backend names the wrapped cache in the spans, such as "redis".
+	Backend string
+	// Tracer starts the spans. Defaults to the tracer of the global
+	// provider.
+	Tracer trace.Tracer
+	// HashKeys records a hash of the keys instead of the keys, for keys
+	// holding personal data.
+	HashKeys bool
+}
+
+// Cache wraps a cache.Cache so that its operations can be traced. Calls made
+// through it directly are not traced; those made through WithContext are.
+type Cache struct {
+	cache.Cache
+	backend  string
+	tracer   trace.Tracer
+	hashKeys bool
+}
+
+// New returns a Cache tracing the operations of c.
+func New(c cache.Cache, opts Opts) *Cache {
+	if opts.Tracer == nil {
+		opts.Tracer = otel.Tracer(instrumentationName)
+	}
+
+	return &Cache{
+		Cache:    c,
+		backend:  opts.Backend,
+		tracer:   opts.Tracer,
+		hashKeys: opts.HashKeys,
+	}
+}
+
+// WithContext returns a cache.Cache starting a span, as a child of the one of
+// ctx, for every operation.
+func (c *Cache) WithContext(ctx context.Context) *ContextCache {
+	return &ContextCache{c: c, ctx: ctx}
+}
+
+// ContextCache is a Cache bound to a context, returned by WithContext.
+type ContextCache struct {
+	c   *Cache
+	ctx context.Context
+}
+
+func (c *ContextCache) key(key string) string {
+	if !c.c.hashKeys {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// start starts the span of operation op.
+func (c *ContextCache) start(op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if c.c.backend != "" {
+		attrs = append(attrs, BackendKey.String(c.c.backend))
+	}
+
+	return c.c.tracer.Start(c.ctx, "cache."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// end records the outcome of the operation, and ends its span.
+func end(span trace.Span, err error) {
+	switch err {
+	case nil, cache.ErrNotStored:
+	case cache.ErrCacheMiss, cache.ErrNegativeHit:
+		span.SetAttributes(HitKey.Bool(false))
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// encode returns value as the JSON every backend stores, so that its size can
+// be recorded without encoding it twice.
+func encode(span trace.Span, value interface{}) (json.RawMessage, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(ValueSizeKey.Int(len(b)))
+	return json.RawMessage(b), nil
+}
+
+func (c *ContextCache) Get(key string, ptrValue interface{}) error {
+	_, span := c.start("get", KeyKey.String(c.key(key)))
+	err := c.get(span, key, ptrValue)
+	end(span, err)
+	return err
+}
+
+func (c *ContextCache) get(span trace.Span, key string, ptrValue interface{}) error {
+	var b json.RawMessage
+	if err := c.c.Cache.Get(key, &b); err != nil {
+		return err
+	}
+
+	span.SetAttributes(HitKey.Bool(true), ValueSizeKey.Int(len(b)))
+	return json.Unmarshal(b, ptrValue)
+}
+
+// GetOrLoad gets the value of key, calling load when it is missing, within a
+// span of which the load is a child. If the wrapped cache has a GetOrLoad,
+// such as cache.RevalidatingCache, it is the one called.
+func (c *ContextCache) GetOrLoad(key string, ptrValue interface{}, load cache.Loader) error {
+	ctx, span := c.start("get_or_load", KeyKey.String(c.key(key)))
+
+	traced := func(key string) (interface{}, error) {
+		_, loadSpan := c.c.tracer.Start(ctx, "cache.load")
+		value, err := load(key)
+		if err != nil {
+			loadSpan.RecordError(err)
+			loadSpan.SetStatus(codes.Error, err.Error())
+		}
+		loadSpan.End()
+		return value, err
+	}
+
+	var err error
+	if loader, ok := c.c.Cache.(interface {
+		GetOrLoad(key string, ptrValue interface{}, load cache.Loader) error
+	}); ok {
+		err = loader.GetOrLoad(key, ptrValue, traced)
+	} else {
+		err = c.getOrLoad(span, key, ptrValue, traced)
+	}
+
+	end(span, err)
+	return err
+}
+
+func (c *ContextCache) getOrLoad(span trace.Span, key string, ptrValue interface{}, load cache.Loader) error {
+	err := c.get(span, key, ptrValue)
+	if err != cache.ErrCacheMiss {
+		return err
+	}
+
+	span.SetAttributes(HitKey.Bool(false))
+	value, err := load(key)
+	if err != nil {
+		return err
+	}
+
+	b, err := encode(span, value)
+	if err != nil {
+		return err
+	}
+
+	if err := c.c.Cache.Set(key, b, cache.DefaultExpiryTime); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, ptrValue)
+}
+
+func (c *ContextCache) GetMulti(keys ...string) (cache.Getter, error) {
+	_, span := c.start("get_multi", KeysKey.Int(len(keys)))
+	g, err := c.c.Cache.GetMulti(keys...)
+	end(span, err)
+	return g, err
+}
+
+func (c *ContextCache) Set(key string, value interface{}, expires time.Duration) error {
+	_, span := c.start("set", KeyKey.String(c.key(key)))
+	b, err := encode(span, value)
+	if err == nil {
+		err = c.c.Cache.Set(key, b, expires)
+	}
+
+	end(span, err)
+	return err
+}
+
+func (c *ContextCache) SetFields(key string, value map[string]interface{}, expires time.Duration) error {
+	_, span := c.start("set_fields", KeyKey.String(c.key(key)))
+	err := c.c.Cache.SetFields(key, value, expires)
+	end(span, err)
+	return err
+}
+
+func (c *ContextCache) Add(key string, value interface{}, expires time.Duration) error {
+	_, span := c.start("add", KeyKey.String(c.key(key)))
+	b, err := encode(span, value)
+	if err == nil {
+		err = c.c.Cache.Add(key, b, expires)
+	}
+
+	end(span, err)
+	return err
+}
+
+func (c *ContextCache) Replace(key string, value interface{}, expires time.Duration) error {
+	_, span := c.start("replace", KeyKey.String(c.key(key)))
+	b, err := encode(span, value)
+	if err == nil {
+		err = c.c.Cache.Replace(key, b, expires)
+	}
+
+	end(span, err)
+	return err
+}
+
+func (c *ContextCache) Delete(key string) error {
+	_, span := c.start("delete", KeyKey.String(c.key(key)))
+	err := c.c.Cache.Delete(key)
+	end(span, err)
+	return err
+}
+
+func (c *ContextCache) Flush() error {
+	_, span := c.start("flush")
+	err := c.c.Cache.Flush()
+	end(span, err)
+	return err
+}
+
+func (c *ContextCache) Keys() ([]string, error) {
+	_, span := c.start("keys")
+	keys, err := c.c.Cache.Keys()
+	end(span, err)
+	return keys, err
+}
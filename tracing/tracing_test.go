@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cache "github.com/oogway/go-cache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestCache(c cache.Cache, opts Opts) (*Cache, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	opts.Tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	return New(c, opts), recorder
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestCache_Spans(t *testing.T) {
+	c, recorder := newTestCache(cache.NewInMemoryCache(time.Hour), Opts{Backend: "memory"})
+	traced := c.WithContext(context.Background())
+
+	var value string
+	traced.Set("key", "foo", time.Hour)
+	if err := traced.Get("key", &value); err != nil || value != "foo" {
+		t.Fatalf("Error getting key: %s / %s", err, value)
+	}
+	traced.Get("missing", &value)
+	traced.Get("key", new(int))
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("Expected 4 spans, got %d", len(spans))
+	}
+
+	set := attributes(spans[0])
+	if spans[0].Name() != "cache.set" || set[KeyKey].AsString() != "key" ||
+		set[BackendKey].AsString() != "memory" || set[ValueSizeKey].AsInt64() != 5 {
+		t.Errorf("Unexpected set span %s: %v", spans[0].Name(), set)
+	}
+
+	hit := attributes(spans[1])
+	if spans[1].Name() != "cache.get" || !hit[HitKey].AsBool() || hit[ValueSizeKey].AsInt64() != 5 {
+		t.Errorf("Unexpected hit span %s: %v", spans[1].Name(), hit)
+	}
+
+	miss := attributes(spans[2])
+	if v, ok := miss[HitKey]; !ok || v.AsBool() || spans[2].Status().Code == codes.Error {
+		t.Errorf("Unexpected miss span: %v / %v", miss, spans[2].Status())
+	}
+
+	if spans[3].Status().Code != codes.Error || len(spans[3].Events()) == 0 {
+		t.Errorf("Expected the decoding error to be recorded: %v", spans[3].Status())
+	}
+}
+
+func TestCache_HashKeys(t *testing.T) {
+	c, recorder := newTestCache(cache.NewInMemoryCache(time.Hour), Opts{HashKeys: true})
+	c.WithContext(context.Background()).Delete("user:42")
+
+	key := attributes(recorder.Ended()[0])[KeyKey].AsString()
+	if key == "" || key == "user:42" {
+		t.Errorf("Expected a hashed key, got %q", key)
+	}
+}
+
+func TestCache_GetOrLoad(t *testing.T) {
+	for name, backend := range map[string]cache.Cache{
+		"plain":        cache.NewInMemoryCache(time.Hour),
+		"revalidating": cache.NewRevalidatingCache(cache.NewInMemoryCache(time.Hour), cache.RevalidateOpts{TTL: time.Hour}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, recorder := newTestCache(backend, Opts{})
+			traced := c.WithContext(context.Background())
+
+			var value string
+			err := traced.GetOrLoad("key", &value, func(string) (interface{}, error) {
+				return "foo", nil
+			})
+			if err != nil || value != "foo" {
+				t.Fatalf("Error loading key: %s / %s", err, value)
+			}
+
+			spans := recorder.Ended()
+			if len(spans) != 2 || spans[0].Name() != "cache.load" || spans[1].Name() != "cache.get_or_load" {
+				t.Fatalf("Expected a load span within a get_or_load one, got %v", spans)
+			}
+
+			if spans[0].Parent().SpanID() != spans[1].SpanContext().SpanID() {
+				t.Errorf("Expected the load span to be a child of the get_or_load one")
+			}
+
+			errLoad := errors.New("not found")
+			traced.GetOrLoad("other", &value, func(string) (interface{}, error) {
+				return nil, errLoad
+			})
+
+			for _, span := range recorder.Ended()[2:] {
+				if span.Status().Code != codes.Error {
+					t.Errorf("Expected %s to record the load error", span.Name())
+				}
+			}
+		})
+	}
+}